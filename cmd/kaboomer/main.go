@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"kaboomer/internal/downloader"
+	"kaboomer/internal/localmusic"
 	"kaboomer/internal/manager"
 	"kaboomer/internal/player"
+	"kaboomer/internal/playlists"
 	"kaboomer/internal/server"
 	"kaboomer/internal/youtube"
 	"log"
@@ -17,6 +19,21 @@ import (
 func main() {
 	port := flag.String("port", ":8080", "Port to run the server on")
 	cookies := flag.String("cookies", "cookies.txt", "Path to cookies.txt for YouTube auth")
+	resume := flag.Bool("resume", false, "Resume the saved current track from its last position on startup")
+	downloads := flag.Int("downloads", 1, "Number of tracks to download at the same time")
+	cacheMaxMB := flag.Int64("cache-max-mb", 1024, "Maximum size of the download cache in MB (0 for unlimited)")
+	cacheMaxFiles := flag.Int("cache-max-files", 0, "Maximum number of files in the download cache (0 for unlimited)")
+	persistYtCache := flag.Bool("persist-yt-cache", false, "Keep the search and metadata cache across restarts")
+	musicDir := flag.String("music-dir", "", "Directory of local audio files to search alongside YouTube")
+	searchTimeout := flag.Duration("search-timeout", youtube.DefaultTimeouts.Search, "Time limit for a YouTube search or metadata lookup (0 for none)")
+	expandTimeout := flag.Duration("expand-timeout", youtube.DefaultTimeouts.Expand, "Time limit for expanding a playlist or channel URL (0 for none)")
+	downloadTimeout := flag.Duration("download-timeout", downloader.DefaultTimeout, "Time limit for downloading one track (0 for none)")
+	downloadAttempts := flag.Int("download-attempts", manager.DefaultRetryPolicy.MaxAttempts, "Times to try a download before giving up (1 disables retries)")
+	retryDelay := flag.Duration("retry-delay", manager.DefaultRetryPolicy.BaseDelay, "Wait before retrying a failed download, doubled for each further retry")
+	normalize := flag.String("normalize", string(manager.DefaultNormalization.Mode), "Loudness normalisation: off, track or album")
+	targetLUFS := flag.Float64("target-lufs", manager.DefaultNormalization.TargetLUFS, "Loudness to normalise tracks to, in LUFS")
+	gapless := flag.Bool("gapless", false, "Preload the next track so it follows the current one without a gap")
+	crossfade := flag.Float64("crossfade", 0, "Seconds to fade out the end of each track and fade in the next (0 for none)")
 	flag.Parse()
 
 	// Get absolute path for static files (assuming running from project root or binary location)
@@ -26,6 +43,9 @@ func main() {
 	}
 	staticDir := filepath.Join(cwd, "web", "static")
 	cacheDir := filepath.Join(cwd, "cache")
+	statePath := filepath.Join(cwd, "kaboomer_state.json")
+	ytCachePath := filepath.Join(cwd, "youtube_cache.json")
+	playlistDir := filepath.Join(cwd, "playlists")
 
 	// Resolve yt-dlp path
 	ytDlpPath := "yt-dlp"
@@ -46,18 +66,62 @@ func main() {
 
 	// Initialize YouTube Service
 	yt := youtube.New(*cookies, ytDlpPath)
+	yt.SetTimeouts(youtube.Timeouts{Search: *searchTimeout, Expand: *expandTimeout})
+	if *persistYtCache {
+		if err := yt.LoadCache(ytCachePath); err != nil {
+			log.Printf("Failed to load youtube cache: %v", err)
+		}
+	}
 	
 	// Initialize Downloader
 	dl, err := downloader.New(ytDlpPath, cacheDir)
 	if err != nil {
 		log.Fatalf("Failed to initialize downloader: %v", err)
 	}
+	dl.SetLimits(*cacheMaxMB*1024*1024, *cacheMaxFiles)
+	dl.SetTimeout(*downloadTimeout)
 	
 	// Initialize Manager
 	mgr := manager.New(p, dl, yt)
+	mgr.SetDownloadConcurrency(*downloads)
+	mgr.SetRetryPolicy(manager.RetryPolicy{
+		MaxAttempts: *downloadAttempts,
+		BaseDelay:   *retryDelay,
+		MaxDelay:    manager.DefaultRetryPolicy.MaxDelay,
+	})
+	if err := mgr.SetNormalization(manager.Normalization{Mode: manager.NormalizeMode(*normalize), TargetLUFS: *targetLUFS}); err != nil {
+		log.Fatal(err)
+	}
+	if err := mgr.SetTransition(manager.Transition{Gapless: *gapless, Crossfade: *crossfade}); err != nil {
+		log.Fatal(err)
+	}
+	if err := mgr.StartLoudnessAnalysis("ffmpeg"); err != nil {
+		log.Printf("%v", err)
+	}
+	if err := mgr.RestoreState(statePath, *resume); err != nil {
+		log.Printf("Failed to restore queue: %v", err)
+	}
+	go mgr.PruneCache()
+
+	// Index the local music directory, if any
+	var local *localmusic.Library
+	if *musicDir != "" {
+		local = localmusic.New(*musicDir)
+		mgr.AddLocalDir(*musicDir)
+		go func() {
+			if err := local.Scan(); err != nil {
+				log.Printf("Failed to scan music dir: %v", err)
+			}
+		}()
+	}
+
+	lists, err := playlists.New(playlistDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize playlists: %v", err)
+	}
 
 	// Initialize Server
-	srv := server.New(mgr, yt, staticDir)
+	srv := server.New(mgr, yt, local, lists, staticDir)
 
 	// Channel to listen for interrupt signals
 	stop := make(chan os.Signal, 1)
@@ -73,5 +137,11 @@ func main() {
 
 	<-stop
 	log.Println("Shutting down...")
+	if err := mgr.SaveState(); err != nil {
+		log.Printf("Failed to save queue: %v", err)
+	}
+	if err := yt.SaveCache(); err != nil {
+		log.Printf("Failed to save youtube cache: %v", err)
+	}
 	p.Stop()
 }
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"log"
+	"os"
+	"sort"
+)
+
+// CacheUsage describes how much of the cache budget is in use.
+// A limit of 0 means unlimited.
+type CacheUsage struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	MaxFiles int   `json:"max_files"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// PruneResult describes what a prune removed
+type PruneResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// SetLimits sets the cache budget. Zero disables a limit.
+func (d *Downloader) SetLimits(maxBytes int64, maxFiles int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.maxBytes = maxBytes
+	d.maxFiles = maxFiles
+}
+
+// Usage reports the current cache size against the budget
+func (d *Downloader) Usage() (CacheUsage, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	usage := CacheUsage{MaxFiles: d.maxFiles, MaxBytes: d.maxBytes}
+	usage.Files = len(d.index)
+	for _, e := range d.index {
+		usage.Bytes += e.Size
+	}
+	return usage, nil
+}
+
+// Prune evicts least recently used tracks until the cache fits its budget.
+// Files in keep (by path) and tracks that are downloading are never removed.
+func (d *Downloader) Prune(keep map[string]bool) (PruneResult, error) {
+	var result PruneResult
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	entries := make([]*Entry, 0, len(d.index))
+	var total int64
+	for _, e := range d.index {
+		entries = append(entries, e)
+		total += e.Size
+	}
+	count := len(entries)
+	over := func() bool {
+		return (d.maxBytes > 0 && total > d.maxBytes) || (d.maxFiles > 0 && count > d.maxFiles)
+	}
+	if !over() {
+		return result, nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed().Before(entries[j].lastUsed())
+	})
+
+	for _, e := range entries {
+		if !over() {
+			break
+		}
+		if _, busy := d.idLocks[e.ID]; keep[e.Path] || busy {
+			continue
+		}
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to evict %s: %v", e.Path, err)
+			continue
+		}
+		delete(d.index, e.ID)
+		total -= e.Size
+		count--
+		result.Files++
+		result.Bytes += e.Size
+	}
+
+	if result.Files > 0 {
+		log.Printf("Evicted %d cached files (%d bytes)", result.Files, result.Bytes)
+		if err := d.saveIndex(); err != nil {
+			return result, err
+		}
+	}
+	if over() {
+		log.Printf("Cache still over budget, everything left is queued or downloading")
+	}
+	return result, nil
+}
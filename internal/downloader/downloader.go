@@ -1,18 +1,38 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Downloader struct {
 	ytDlpPath string
 	cacheDir  string
 	mutex     sync.Mutex
+	idLocks   map[string]*idLock // Serialises downloads of the same track
+	maxBytes  int64              // Cache budget, 0 for unlimited
+	maxFiles  int
+	index     map[string]*Entry // Cached tracks by id, see index.go
+	timeout   time.Duration     // Per download, 0 for no limit
+	analysis  chan string       // Track ids to measure, nil until StartAnalysis
+}
+
+// DefaultTimeout bounds one download. Long mixes on a slow link take a
+// while, a yt-dlp stuck on a stalled connection takes forever.
+const DefaultTimeout = 15 * time.Minute
+
+type idLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
 func New(ytDlpPath string, cacheDir string) (*Downloader, error) {
@@ -25,72 +45,189 @@ func New(ytDlpPath string, cacheDir string) (*Downloader, error) {
 		return nil, fmt.Errorf("failed to create cache dir: %w", err)
 	}
 
-	return &Downloader{
+	d := &Downloader{
 		ytDlpPath: ytDlpPath,
 		cacheDir:  cacheDir,
-	}, nil
+		idLocks:   make(map[string]*idLock),
+		timeout:   DefaultTimeout,
+	}
+	if err := d.loadIndex(); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-// Download downloads the video audio to the cache directory.
-// It returns the path to the downloaded file.
-// It is thread-safe and ensures sequential downloads if called concurrently (via mutex),
-// but for a queue system, the caller should probably manage the queueing.
-func (d *Downloader) Download(url string, id string) (string, error) {
+// CacheDir returns the directory downloads are stored in
+func (d *Downloader) CacheDir() string {
+	return d.cacheDir
+}
+
+// SetTimeout changes how long one download may run, 0 for no limit
+func (d *Downloader) SetTimeout(timeout time.Duration) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
+	d.timeout = timeout
+}
 
-	// Pattern to find existing files: id.*
-	// We use yt-dlp to determine extension, but we need to find it after.
-	// Or we force an extension. M4A is usually safe and low CPU (AAC). 
-	// WebM (Opus) is also good.
-	// Let's force filename to be just ID and let yt-dlp pick extension.
-	
-	outputTemplate := filepath.Join(d.cacheDir, id+".%(ext)s")
-	
-	// Check if file already exists with common audio extensions
-	commonExts := []string{".m4a", ".mp3", ".webm", ".opus", ".aac", ".wav"}
-	for _, ext := range commonExts {
-		path := filepath.Join(d.cacheDir, id+ext)
-		if _, err := os.Stat(path); err == nil {
-			log.Printf("File already cached: %s", path)
-			return path, nil
+// lockID takes the lock for one track id and returns its unlock function.
+// Different tracks download in parallel; the same track never does.
+func (d *Downloader) lockID(id string) func() {
+	d.mutex.Lock()
+	l, ok := d.idLocks[id]
+	if !ok {
+		l = &idLock{}
+		d.idLocks[id] = l
+	}
+	l.refs++
+	d.mutex.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		d.mutex.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(d.idLocks, id)
+		}
+		d.mutex.Unlock()
+	}
+}
+
+// Track identifies a download and the metadata to index it under
+type Track struct {
+	ID     string
+	URL    string
+	Title  string
+	Artist string
+}
+
+// fileLinePrefix marks the line yt-dlp prints once the file is in place
+const fileLinePrefix = "kaboomer-file"
+
+// fileTemplate reports the final path after any post-processing, so we never
+// have to guess which file in the cache directory is the result
+var fileTemplate = "after_move:" + fileLinePrefix + " %(duration)s %(filepath)s"
+
+// parseFileLine reads a line printed with fileTemplate
+func parseFileLine(line string) (path string, duration float64, ok bool) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || fields[0] != fileLinePrefix {
+		return "", 0, false
+	}
+	duration, _ = strconv.ParseFloat(fields[1], 64) // NA if unknown
+	return fields[2], duration, true
+}
+
+// Download downloads the video audio to the cache directory.
+// It returns the path to the downloaded file.
+// It is safe to call concurrently; the caller decides how many downloads run at once.
+// Cancelling ctx kills the running yt-dlp process, as does running past the
+// timeout (see SetTimeout).
+// onProgress, if not nil, is called for every progress update yt-dlp prints.
+// A failed yt-dlp run returns a *DownloadError, see IsRetryable.
+func (d *Downloader) Download(ctx context.Context, t Track, onProgress func(Progress)) (string, error) {
+	unlock := d.lockID(t.ID)
+	defer unlock()
+
+	d.mutex.Lock()
+	timeout := d.timeout
+	if e, ok := d.lookup(t.ID); ok {
+		// Entries rebuilt from a directory scan have no metadata yet
+		if e.Title == "" && t.Title != "" {
+			e.Title, e.Artist, e.SourceURL = t.Title, t.Artist, t.URL
+			if err := d.saveIndex(); err != nil {
+				log.Printf("%v", err)
+			}
 		}
+		d.mutex.Unlock()
+		log.Printf("File already cached: %s", e.Path)
+		return e.Path, nil
 	}
+	d.mutex.Unlock()
 
-	log.Printf("Starting download for %s (%s)", id, url)
+	// Let yt-dlp pick the extension, the index records what it chose
+	outputTemplate := filepath.Join(d.cacheDir, t.ID+".%(ext)s")
+
+	log.Printf("Starting download for %s (%s)", t.ID, t.URL)
 
 	// Arguments for low CPU:
 	// -f bestaudio: Get best audio
 	// --no-mtime: Don't set file time to video time
 	// --no-playlist: Just one video
+	// --newline/--progress-template: One parseable progress line per update
+	// --print: Report the final file; it implies --quiet, so --progress keeps progress lines
 	args := []string{
 		"-f", "bestaudio[ext=m4a]/bestaudio", // Prefer m4a (AAC) for broad compatibility and low decode cost, fallback to best
 		"--no-playlist",
 		"--no-mtime",
+		"--newline",
+		"--progress",
+		"--progress-template", progressTemplate,
+		"--print", fileTemplate,
 		"-o", outputTemplate,
-		url,
+		"--", t.URL, // A URL starting with - is still a URL
 	}
 
-	cmd := exec.Command(d.ytDlpPath, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var path string
+	var duration float64
+	cmd := exec.CommandContext(runCtx, d.ytDlpPath, args...)
+	cmd.Stdout = progressWriter(onProgress, func(line string) bool {
+		p, dur, ok := parseFileLine(line)
+		if ok {
+			path, duration = p, dur
+		}
+		return ok
+	})
+	// Keep yt-dlp's messages in the log, and the last of them to classify failures
+	var stderr tailWriter
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	// ffmpeg children may hold stdout open after yt-dlp is killed
+	cmd.WaitDelay = 5 * time.Second
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("yt-dlp download failed: %w", err)
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("yt-dlp download cancelled: %w", ctx.Err())
+		}
+		if runCtx.Err() != nil {
+			return "", fmt.Errorf("yt-dlp download timed out after %s: %w", timeout, runCtx.Err())
+		}
+		return "", classify(err, stderr.String())
 	}
 
-	// Find the file that was created
-	matches, err := filepath.Glob(filepath.Join(d.cacheDir, id+".*"))
+	if path == "" {
+		return "", fmt.Errorf("download finished but yt-dlp did not report a file for id %s", t.ID)
+	}
+	info, err := os.Stat(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to glob downloaded file: %w", err)
+		return "", fmt.Errorf("downloaded file missing: %w", err)
 	}
-	if len(matches) == 0 {
-		return "", fmt.Errorf("download finished but file not found for id %s", id)
+
+	entry := &Entry{
+		ID:           t.ID,
+		Path:         path,
+		Format:       strings.TrimPrefix(filepath.Ext(path), "."),
+		Size:         info.Size(),
+		Duration:     duration,
+		Title:        t.Title,
+		Artist:       t.Artist,
+		SourceURL:    t.URL,
+		DownloadedAt: time.Now(),
+	}
+	d.mutex.Lock()
+	d.index[t.ID] = entry
+	if err := d.saveIndex(); err != nil {
+		log.Printf("%v", err)
 	}
+	d.queueAnalysis(t.ID)
+	d.mutex.Unlock()
 
-	log.Printf("Download finished: %s", matches[0])
-	return matches[0], nil
+	log.Printf("Download finished: %s", path)
+	return path, nil
 }
-
-// PruneOldFiles could be added later to clean up cache
-
@@ -0,0 +1,385 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"kaboomer/internal/ytdlptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	ytdlptest.Main()
+	os.Exit(m.Run())
+}
+
+func newTestDownloader(t *testing.T, script ytdlptest.Script) (*Downloader, *ytdlptest.Fake) {
+	t.Helper()
+	fake := ytdlptest.New(t, script)
+	d, err := New(fake.Path, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, fake
+}
+
+var testTrack = Track{ID: "a1", URL: "https://www.youtube.com/watch?v=a1", Title: "Song", Artist: "Band"}
+
+func TestDownload(t *testing.T) {
+	tests := []struct {
+		name     string
+		download ytdlptest.Download
+		wantExt  string
+		wantDur  float64
+	}{
+		{"m4a with duration", ytdlptest.Download{Ext: "m4a", Size: 4000, Duration: 212.5, Updates: 4}, "m4a", 212.5},
+		{"webm fallback", ytdlptest.Download{Ext: "webm", Size: 1000, Updates: 1}, "webm", 0},
+		{"no progress", ytdlptest.Download{Size: 10}, "m4a", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, fake := newTestDownloader(t, ytdlptest.Script{Download: &tt.download})
+
+			var updates []Progress
+			path, err := d.Download(context.Background(), testTrack, func(p Progress) {
+				updates = append(updates, p)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := filepath.Join(d.cacheDir, "a1."+tt.wantExt); path != want {
+				t.Errorf("path = %q, want %q", path, want)
+			}
+			if len(updates) != tt.download.Updates {
+				t.Errorf("got %d progress updates, want %d", len(updates), tt.download.Updates)
+			}
+			if n := len(updates); n > 0 && updates[n-1].Percent != 100 {
+				t.Errorf("last progress = %+v, want 100%%", updates[n-1])
+			}
+
+			e, ok := d.Lookup("a1")
+			if !ok {
+				t.Fatal("download not in the index")
+			}
+			if e.Path != path || e.Format != tt.wantExt || e.Size != int64(tt.download.Size) ||
+				e.Duration != tt.wantDur || e.Title != "Song" || e.Artist != "Band" || e.SourceURL != testTrack.URL {
+				t.Errorf("index entry = %+v", e)
+			}
+
+			// The second time it comes from the cache
+			again, err := d.Download(context.Background(), testTrack, nil)
+			if err != nil || again != path {
+				t.Errorf("second Download() = %q, %v; want %q", again, err, path)
+			}
+			if n := len(fake.Calls(t)); n != 1 {
+				t.Errorf("yt-dlp ran %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDownloadArgs(t *testing.T) {
+	d, fake := newTestDownloader(t, ytdlptest.Script{Download: &ytdlptest.Download{Size: 1}})
+	if _, err := d.Download(context.Background(), testTrack, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	args := fake.Calls(t)[0]
+	for _, want := range []string{"--no-playlist", "--progress-template", progressTemplate, "--print", fileTemplate, filepath.Join(d.cacheDir, "a1.%(ext)s")} {
+		if !slices.Contains(args, want) {
+			t.Errorf("args %q lack %q", args, want)
+		}
+	}
+	if !slices.Equal(args[len(args)-2:], []string{"--", testTrack.URL}) {
+		t.Errorf("args %q don't end with the URL after --", args)
+	}
+}
+
+func TestDownloadFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    ytdlptest.Script
+		ctxErr    error // Expected in the chain, if any
+		retryable bool
+	}{
+		{
+			name:      "private video",
+			script:    ytdlptest.Script{Stderr: "WARNING: something\nERROR: [youtube] a1: Private video. Sign in if you've been granted access\n", Exit: 1},
+			retryable: false,
+		},
+		{
+			name:      "removed video",
+			script:    ytdlptest.Script{Stderr: "ERROR: [youtube] a1: Video unavailable. This video has been removed by the uploader\n", Exit: 1},
+			retryable: false,
+		},
+		{
+			name:      "throttled",
+			script:    ytdlptest.Script{Stderr: "ERROR: unable to download video data: HTTP Error 429: Too Many Requests\n", Exit: 1},
+			retryable: true,
+		},
+		{
+			name:      "not found",
+			script:    ytdlptest.Script{Stderr: "ERROR: unable to download video data: HTTP Error 404: Not Found\n", Exit: 1},
+			retryable: false,
+		},
+		{
+			name:      "network",
+			script:    ytdlptest.Script{Stderr: "ERROR: [Errno -3] Temporary failure in name resolution\n", Exit: 1},
+			retryable: true,
+		},
+		{
+			name:      "no file reported",
+			script:    ytdlptest.Script{},
+			retryable: true,
+		},
+		{
+			name:      "hang",
+			script:    ytdlptest.Script{Hang: true},
+			ctxErr:    context.DeadlineExceeded,
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newTestDownloader(t, tt.script)
+			d.SetTimeout(200 * time.Millisecond)
+
+			_, err := d.Download(context.Background(), testTrack, nil)
+			if err == nil {
+				t.Fatal("Download() succeeded")
+			}
+			if tt.ctxErr != nil && !errors.Is(err, tt.ctxErr) {
+				t.Errorf("Download() error = %v, want %v", err, tt.ctxErr)
+			}
+			if got := IsRetryable(err); got != tt.retryable {
+				t.Errorf("IsRetryable(%v) = %v, want %v", err, got, tt.retryable)
+			}
+			if _, ok := d.Lookup("a1"); ok {
+				t.Error("failed download is in the index")
+			}
+		})
+	}
+}
+
+func TestDownloadWithoutYtDlp(t *testing.T) {
+	dir := t.TempDir()
+	notExecutable := filepath.Join(dir, "yt-dlp")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing"), notExecutable} {
+		d, err := New(path, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = d.Download(context.Background(), testTrack, nil)
+		if err == nil {
+			t.Fatalf("Download() with %s succeeded", path)
+		}
+		if IsRetryable(err) {
+			t.Errorf("IsRetryable(%v) = true, want false", err)
+		}
+	}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	d, _ := newTestDownloader(t, ytdlptest.Script{Hang: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := d.Download(ctx, testTrack, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Download() error = %v, want context.Canceled", err)
+	}
+	if IsRetryable(err) {
+		t.Error("a cancelled download is retryable")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		stderr    string
+		reason    string
+		retryable bool
+	}{
+		{
+			stderr: "[youtube] Extracting URL: https://www.youtube.com/watch?v=a1\nERROR: [youtube] a1: Private video. Sign in if you've been granted access to this video\n",
+			reason: "[youtube] a1: Private video. Sign in if you've been granted access to this video",
+		},
+		{
+			stderr: "ERROR: [youtube] a1: Video unavailable. This video is not available in your country\n",
+			reason: "[youtube] a1: Video unavailable. This video is not available in your country",
+		},
+		{
+			stderr: "ERROR: [youtube] a1: Sign in to confirm your age. This video may be inappropriate for some users.\n",
+			reason: "[youtube] a1: Sign in to confirm your age. This video may be inappropriate for some users.",
+		},
+		{
+			stderr: "ERROR: [youtube] a1: Join this channel to get access to members-only content like this video, and other exclusive perks.\n",
+			reason: "[youtube] a1: Join this channel to get access to members-only content like this video, and other exclusive perks.",
+		},
+		{
+			stderr: "ERROR: [youtube] a1: Requested format is not available. Use --list-formats for a list of available formats\n",
+			reason: "[youtube] a1: Requested format is not available. Use --list-formats for a list of available formats",
+		},
+		{
+			// Unavailable videos may also answer 403, the permanent reason wins
+			stderr: "ERROR: [youtube] a1: Video unavailable. This video has been removed for violating YouTube's Terms of Service (HTTP Error 403)\n",
+			reason: "[youtube] a1: Video unavailable. This video has been removed for violating YouTube's Terms of Service (HTTP Error 403)",
+		},
+		{
+			// Client errors but 429 answer the same next time
+			stderr: "[download]  12.3% of    3.45MiB at  1.20MiB/s ETA 00:02\nERROR: unable to download video data: HTTP Error 403: Forbidden\n",
+			reason: "unable to download video data: HTTP Error 403: Forbidden",
+		},
+		{
+			stderr: "ERROR: [youtube] a1: Unable to download webpage: HTTP Error 404: Not Found (caused by <HTTPError 404: Not Found>)\n",
+			reason: "[youtube] a1: Unable to download webpage: HTTP Error 404: Not Found (caused by <HTTPError 404: Not Found>)",
+		},
+		{
+			stderr:    "ERROR: unable to download video data: HTTP Error 503: Service Unavailable\n",
+			reason:    "unable to download video data: HTTP Error 503: Service Unavailable",
+			retryable: true,
+		},
+		{
+			stderr:    "ERROR: [youtube] a1: Unable to download API page: HTTP Error 429: Too Many Requests (caused by <HTTPError 429: Too Many Requests>)\n",
+			reason:    "[youtube] a1: Unable to download API page: HTTP Error 429: Too Many Requests (caused by <HTTPError 429: Too Many Requests>)",
+			retryable: true,
+		},
+		{
+			stderr:    "ERROR: [youtube] a1: Sign in to confirm you’re not a bot. Use --cookies-from-browser or --cookies for the authentication.\n",
+			reason:    "[youtube] a1: Sign in to confirm you’re not a bot. Use --cookies-from-browser or --cookies for the authentication.",
+			retryable: true,
+		},
+		{
+			stderr:    "ERROR: [youtube] a1: Unable to download webpage: <urlopen error [Errno -3] Temporary failure in name resolution> (caused by TransportError('<urlopen error [Errno -3] Temporary failure in name resolution>'))\n",
+			reason:    "[youtube] a1: Unable to download webpage: <urlopen error [Errno -3] Temporary failure in name resolution> (caused by TransportError('<urlopen error [Errno -3] Temporary failure in name resolution>'))",
+			retryable: true,
+		},
+		{
+			stderr:    "[download] Got error: The read operation timed out. Retrying (1/10)...\nERROR: fragment 1 not found, unable to continue\nERROR: The read operation timed out\n",
+			reason:    "The read operation timed out",
+			retryable: true,
+		},
+		{
+			stderr:    "ERROR: [youtube] a1: Unable to download webpage: [SSL: UNEXPECTED_EOF_WHILE_READING] EOF occurred in violation of protocol (_ssl.c:1006)\n",
+			reason:    "[youtube] a1: Unable to download webpage: [SSL: UNEXPECTED_EOF_WHILE_READING] EOF occurred in violation of protocol (_ssl.c:1006)",
+			retryable: true,
+		},
+		{
+			// Unknown messages don't get better by trying again
+			stderr: "ERROR: Postprocessing: audio conversion failed: Error opening output files: Invalid argument\n",
+			reason: "Postprocessing: audio conversion failed: Error opening output files: Invalid argument",
+		},
+		{
+			// Died without saying why, e.g. killed
+			stderr:    "[download]  50.0% of    3.45MiB\n",
+			reason:    "exit status 1",
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got := classify(exit, tt.stderr)
+		if got.Reason != tt.reason || got.Retryable != tt.retryable || got.Err != exit {
+			t.Errorf("classify(%q) = %q, retryable %v; want %q, retryable %v", tt.stderr, got.Reason, got.Retryable, tt.reason, tt.retryable)
+		}
+	}
+}
+
+func TestRebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{
+		"a1.m4a":            100,
+		"b2.webm":           200,
+		"c3.m4a.part":       50, // Interrupted download
+		"d4.f140.m4a":       50, // Unmerged fragment
+		"e5.temp":           50,
+		"f6.x.m4a":          300, // Dot in the id
+		"g7.m4a.part-Frag2": 50,
+	}
+	for name, size := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := New("yt-dlp", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range d.Entries() {
+		ids = append(ids, e.ID)
+	}
+	slices.Sort(ids)
+	if want := []string{"a1", "b2", "f6.x"}; !slices.Equal(ids, want) {
+		t.Errorf("indexed %v, want %v", ids, want)
+	}
+	if e, _ := d.Lookup("b2"); e.Format != "webm" || e.Size != 200 {
+		t.Errorf("b2 = %+v", e)
+	}
+
+	// A file deleted behind our back drops out on lookup
+	os.Remove(filepath.Join(dir, "a1.m4a"))
+	if _, ok := d.Lookup("a1"); ok {
+		t.Error("Lookup found a deleted file")
+	}
+}
+
+func TestParseLoudnorm(t *testing.T) {
+	measurement := func(i, tp, lra string) string {
+		return `[Parsed_loudnorm_0 @ 0x55d0c8e2c0] 
+{
+	"input_i" : "` + i + `",
+	"input_tp" : "` + tp + `",
+	"input_lra" : "` + lra + `",
+	"input_thresh" : "-19.32",
+	"output_i" : "-24.01",
+	"target_offset" : "0.01"
+}
+`
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    Loudness
+		wantErr bool
+	}{
+		{
+			name:   "measured",
+			output: "Input #0, mov,mp4,m4a, from 'a1.m4a':\n  Duration: 00:03:32.50\n" + measurement("-9.21", "0.87", "5.40"),
+			want:   Loudness{Integrated: -9.21, TruePeak: 0.87, Range: 5.4},
+		},
+		{name: "silent", output: measurement("-inf", "-inf", "0.00"), wantErr: true},
+		{name: "no measurement", output: "a1.m4a: Invalid data found when processing input\n", wantErr: true},
+		{name: "garbled", output: measurement("loud", "0.87", "5.40"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLoudnorm([]byte(tt.output))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseLoudnorm() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseLoudnorm() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DownloadError is a failed yt-dlp run, classified from what it printed
+type DownloadError struct {
+	Reason    string // yt-dlp's own error message, or the exit status
+	Retryable bool   // Trying again later may work
+	Err       error
+}
+
+func (e *DownloadError) Error() string {
+	return "yt-dlp download failed: " + e.Reason
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// permanentErrors are yt-dlp messages no retry will fix, matched lowercased.
+// They are checked before retryableErrors: an unavailable video may also
+// answer 403.
+var permanentErrors = []string{
+	"private video",
+	"video unavailable",
+	"has been removed",
+	"account associated with this video has been terminated",
+	"copyright",
+	"not available in your country",
+	"confirm your age",
+	"members-only",
+	"join this channel",
+	"unsupported url",
+	"is not a valid url",
+	"incomplete youtube id",
+	"requested format is not available",
+}
+
+// httpStatus finds the status in yt-dlp's "HTTP Error 404: Not Found"
+var httpStatus = regexp.MustCompile(`http error (\d{3})`)
+
+// retryableErrors are network trouble and throttling without an HTTP status
+var retryableErrors = []string{
+	"timed out",
+	"connection reset",
+	"connection refused",
+	"connection aborted",
+	"remote end closed connection",
+	"temporary failure in name resolution",
+	"network is unreachable",
+	"incompleteread",
+	"ssl",
+	"giving up after",
+	"not a bot",
+}
+
+// classify turns a failed run into a DownloadError. yt-dlp errors we don't
+// recognise are permanent, so they don't use up retries on something that
+// will fail the same way; so are HTTP client errors but 429, and a yt-dlp
+// that can't be run at all. A run that died without an error message, e.g.
+// killed, is retryable.
+func classify(err error, stderr string) *DownloadError {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return &DownloadError{Reason: err.Error(), Retryable: false, Err: err}
+	}
+	reason := lastError(stderr)
+	if reason == "" {
+		return &DownloadError{Reason: err.Error(), Retryable: true, Err: err}
+	}
+
+	lower := strings.ToLower(reason)
+	for _, s := range permanentErrors {
+		if strings.Contains(lower, s) {
+			return &DownloadError{Reason: reason, Retryable: false, Err: err}
+		}
+	}
+	if m := httpStatus.FindStringSubmatch(lower); m != nil {
+		retryable := m[1] == "429" || m[1][0] == '5'
+		return &DownloadError{Reason: reason, Retryable: retryable, Err: err}
+	}
+	for _, s := range retryableErrors {
+		if strings.Contains(lower, s) {
+			return &DownloadError{Reason: reason, Retryable: true, Err: err}
+		}
+	}
+	return &DownloadError{Reason: reason, Retryable: false, Err: err}
+}
+
+// lastError returns the last "ERROR:" line yt-dlp printed, without the prefix
+func lastError(stderr string) string {
+	lines := strings.Split(stderr, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if msg, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "ERROR:"); ok {
+			return strings.TrimSpace(msg)
+		}
+	}
+	return ""
+}
+
+// IsRetryable reports whether a Download error may go away by trying again.
+// Timeouts are retryable, cancellations and a yt-dlp that can't be run are not.
+func IsRetryable(err error) bool {
+	var dlErr *DownloadError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrPermission):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &dlErr):
+		return dlErr.Retryable
+	}
+	return true
+}
+
+// maxStderrTail is how much of yt-dlp's stderr is kept for classify
+const maxStderrTail = 8 << 10
+
+// tailWriter keeps the last maxStderrTail bytes written to it
+type tailWriter struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - maxStderrTail; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"kaboomer/internal/atomicfile"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// indexFile is the name of the cache index inside the cache directory
+const indexFile = "index.json"
+
+// Entry describes one cached track
+type Entry struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	Format       string    `json:"format"` // File extension, e.g. m4a
+	Size         int64     `json:"size"`
+	Duration     float64   `json:"duration,omitempty"` // Seconds, 0 if unknown
+	Title        string    `json:"title,omitempty"`
+	Artist       string    `json:"artist,omitempty"`
+	SourceURL    string    `json:"source_url,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	LastPlayedAt time.Time `json:"last_played_at,omitempty"`
+	Loudness     *Loudness `json:"loudness,omitempty"` // nil until measured, see loudness.go
+}
+
+// lastUsed is the eviction key: last played, or downloaded if never played
+func (e *Entry) lastUsed() time.Time {
+	if e.LastPlayedAt.After(e.DownloadedAt) {
+		return e.LastPlayedAt
+	}
+	return e.DownloadedAt
+}
+
+// leftover reports whether name is a partial or temporary file from yt-dlp
+func leftover(name string) bool {
+	for _, ext := range []string{".part", ".ytdl", ".temp", ".tmp"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	if strings.Contains(name, ".part-") {
+		return true // Fragment of a download in progress, e.g. id.m4a.part-Frag3
+	}
+	// Unmerged format, e.g. id.f140.m4a. Ids can contain dots themselves,
+	// so only a format id right before the extension counts.
+	format := filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name)))
+	return len(format) > 2 && format[1] == 'f' && strings.Trim(format[2:], "0123456789") == ""
+}
+
+// loadIndex reads the cache index, rebuilding it from the directory if it is
+// missing or unreadable. d.mutex must be locked.
+func (d *Downloader) loadIndex() error {
+	d.index = make(map[string]*Entry)
+
+	data, err := os.ReadFile(filepath.Join(d.cacheDir, indexFile))
+	if err == nil {
+		var entries []*Entry
+		if err := json.Unmarshal(data, &entries); err == nil {
+			for _, e := range entries {
+				// The cache directory may have moved since the index was written
+				e.Path = filepath.Join(d.cacheDir, filepath.Base(e.Path))
+				d.index[e.ID] = e
+			}
+			return nil
+		}
+		log.Printf("Cache index is corrupt, rebuilding: %v", err)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read cache index: %w", err)
+	}
+
+	return d.rebuildIndex()
+}
+
+// rebuildIndex recreates the index by scanning the cache directory.
+// Only file facts are recoverable; titles are filled in when a track is queued again.
+// d.mutex must be locked.
+func (d *Downloader) rebuildIndex() error {
+	dirEntries, err := os.ReadDir(d.cacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache dir: %w", err)
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if !de.Type().IsRegular() || name == indexFile || strings.HasPrefix(name, ".") || leftover(name) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		ext := filepath.Ext(name)
+		id := strings.TrimSuffix(name, ext)
+		d.index[id] = &Entry{
+			ID:           id,
+			Path:         filepath.Join(d.cacheDir, name),
+			Format:       strings.TrimPrefix(ext, "."),
+			Size:         info.Size(),
+			DownloadedAt: info.ModTime(),
+		}
+	}
+
+	log.Printf("Rebuilt cache index with %d tracks", len(d.index))
+	return d.saveIndex()
+}
+
+// saveIndex writes the index atomically. d.mutex must be locked.
+func (d *Downloader) saveIndex() error {
+	entries := make([]*Entry, 0, len(d.index))
+	for _, e := range d.index {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.Write(filepath.Join(d.cacheDir, indexFile), data); err != nil {
+		return fmt.Errorf("failed to save cache index: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the index entry for id if its file is still on disk.
+// Stale entries are dropped. d.mutex must be locked.
+func (d *Downloader) lookup(id string) (*Entry, bool) {
+	e, ok := d.index[id]
+	if !ok {
+		return nil, false
+	}
+	if _, err := os.Stat(e.Path); err != nil {
+		delete(d.index, id)
+		if err := d.saveIndex(); err != nil {
+			log.Printf("%v", err)
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+// Lookup returns a copy of the cached entry for a track id
+func (d *Downloader) Lookup(id string) (Entry, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	e, ok := d.lookup(id)
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Entries returns a copy of every cached entry
+func (d *Downloader) Entries() []Entry {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entries := make([]Entry, 0, len(d.index))
+	for _, e := range d.index {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// MarkPlayed records that a cached track was just played, which keeps it
+// in the cache longest
+func (d *Downloader) MarkPlayed(id string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	e, ok := d.index[id]
+	if !ok {
+		return
+	}
+	e.LastPlayedAt = time.Now()
+	if err := d.saveIndex(); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// analysisTimeout bounds one ffmpeg run. It decodes the whole track, which
+// takes a while on a Pi Zero but never minutes.
+const analysisTimeout = 5 * time.Minute
+
+// Loudness is the EBU R128 measurement of a cached track
+type Loudness struct {
+	Integrated float64 `json:"integrated_lufs"` // Programme loudness
+	TruePeak   float64 `json:"true_peak_dbtp"`
+	Range      float64 `json:"range_lu"`
+}
+
+// StartAnalysis measures the loudness of every cached track that has not been
+// measured yet, and of each new download, one at a time in the background.
+// onAnalyzed, if not nil, is called with the id of each track measured.
+func (d *Downloader) StartAnalysis(ffmpegPath string, onAnalyzed func(id string)) error {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	path, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found, loudness analysis disabled: %w", err)
+	}
+
+	d.mutex.Lock()
+	if d.analysis != nil {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.analysis = make(chan string, 64)
+	var todo []string
+	for id, e := range d.index {
+		if e.Loudness == nil {
+			todo = append(todo, id)
+		}
+	}
+	d.mutex.Unlock()
+
+	go d.analysisWorker(path, onAnalyzed)
+	go func() {
+		for _, id := range todo {
+			d.analysis <- id
+		}
+	}()
+	if len(todo) > 0 {
+		log.Printf("Measuring loudness of %d cached tracks", len(todo))
+	}
+	return nil
+}
+
+// queueAnalysis asks for a track to be measured. d.mutex must be locked.
+func (d *Downloader) queueAnalysis(id string) {
+	if d.analysis == nil {
+		return
+	}
+	select {
+	case d.analysis <- id:
+	default:
+		// Backed up; it is measured on the next start
+	}
+}
+
+func (d *Downloader) analysisWorker(ffmpegPath string, onAnalyzed func(id string)) {
+	for id := range d.analysis {
+		d.mutex.Lock()
+		e, ok := d.lookup(id)
+		var path string
+		if ok && e.Loudness == nil {
+			path = e.Path
+		}
+		d.mutex.Unlock()
+		if path == "" {
+			continue // Pruned, or measured already
+		}
+
+		l, err := analyze(ffmpegPath, path)
+		if err != nil {
+			log.Printf("Loudness analysis failed for %s: %v", id, err)
+			continue
+		}
+
+		d.mutex.Lock()
+		if e, ok := d.index[id]; ok {
+			e.Loudness = &l
+			if err := d.saveIndex(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		d.mutex.Unlock()
+
+		log.Printf("Loudness of %s: %.1f LUFS, peak %.1f dBTP", id, l.Integrated, l.TruePeak)
+		if onAnalyzed != nil {
+			onAnalyzed(id)
+		}
+	}
+}
+
+// analyze runs ffmpeg's loudnorm filter in measurement mode over the first
+// audio stream of path
+func analyze(ffmpegPath, path string) (Loudness, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), analysisTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-hide_banner", "-nostats", "-nostdin",
+		"-threads", "1",
+		"-i", path,
+		"-map", "0:a:0",
+		"-af", "loudnorm=print_format=json",
+		"-f", "null", "-",
+	)
+	// loudnorm prints its measurement to stderr
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Loudness{}, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	return parseLoudnorm(stderr.Bytes())
+}
+
+// parseLoudnorm reads the JSON block loudnorm prints after the rest of
+// ffmpeg's output. Values are strings and may be -inf for silence.
+func parseLoudnorm(output []byte) (Loudness, error) {
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start == -1 || end < start {
+		return Loudness{}, fmt.Errorf("no loudnorm measurement in ffmpeg output")
+	}
+
+	var raw struct {
+		InputI   string `json:"input_i"`
+		InputTP  string `json:"input_tp"`
+		InputLRA string `json:"input_lra"`
+	}
+	if err := json.Unmarshal(output[start:end+1], &raw); err != nil {
+		return Loudness{}, fmt.Errorf("failed to parse loudnorm measurement: %w", err)
+	}
+
+	var l Loudness
+	var err error
+	if l.Integrated, err = strconv.ParseFloat(raw.InputI, 64); err != nil {
+		return Loudness{}, fmt.Errorf("bad integrated loudness %q", raw.InputI)
+	}
+	if math.IsInf(l.Integrated, 0) {
+		return Loudness{}, fmt.Errorf("track is silent")
+	}
+	if l.TruePeak, err = strconv.ParseFloat(raw.InputTP, 64); err != nil {
+		return Loudness{}, fmt.Errorf("bad true peak %q", raw.InputTP)
+	}
+	l.Range, _ = strconv.ParseFloat(raw.InputLRA, 64)
+	return l, nil
+}
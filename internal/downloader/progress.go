@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StallTimeout is how long a download may go without receiving a byte before
+// it is reported as stalled rather than just slow
+const StallTimeout = 30 * time.Second
+
+// progressPrefix marks the lines produced by progressTemplate
+const progressPrefix = "kaboomer-progress"
+
+// progressTemplate makes yt-dlp print one machine-readable line per update.
+// Missing values are printed as NA.
+var progressTemplate = "download:" + progressPrefix +
+	" %(progress.downloaded_bytes)s" +
+	" %(progress.total_bytes)s" +
+	" %(progress.total_bytes_estimate)s" +
+	" %(progress.speed)s" +
+	" %(progress.eta)s"
+
+// Progress is a snapshot of a running download
+type Progress struct {
+	Percent         float64   `json:"percent"`
+	DownloadedBytes int64     `json:"downloaded_bytes"`
+	TotalBytes      int64     `json:"total_bytes,omitempty"` // Exact size, or yt-dlp's estimate
+	Speed           float64   `json:"speed,omitempty"`       // Bytes per second
+	ETA             int       `json:"eta,omitempty"`         // Seconds
+	UpdatedAt       time.Time `json:"updated_at"`            // Last time the byte count grew
+}
+
+// Stalled reports whether no data has arrived for StallTimeout
+func (p Progress) Stalled() bool {
+	return time.Since(p.UpdatedAt) > StallTimeout
+}
+
+// parseProgress reads a line printed with progressTemplate.
+// prev carries UpdatedAt forward while the byte count stands still.
+func parseProgress(line string, prev Progress) (Progress, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 6 || fields[0] != progressPrefix {
+		return prev, false
+	}
+
+	num := func(s string) float64 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0 // NA
+		}
+		return v
+	}
+
+	p := Progress{
+		DownloadedBytes: int64(num(fields[1])),
+		TotalBytes:      int64(num(fields[2])),
+		Speed:           num(fields[4]),
+		ETA:             int(num(fields[5])),
+		UpdatedAt:       prev.UpdatedAt,
+	}
+	if p.TotalBytes == 0 {
+		p.TotalBytes = int64(num(fields[3]))
+	}
+	if p.TotalBytes > 0 {
+		p.Percent = float64(p.DownloadedBytes) / float64(p.TotalBytes) * 100
+		if p.Percent > 100 {
+			p.Percent = 100
+		}
+	}
+	if p.DownloadedBytes != prev.DownloadedBytes || p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+	return p, true
+}
+
+// lineWriter splits yt-dlp's stdout into lines
+type lineWriter struct {
+	buf  []byte
+	line func(string)
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// progressWriter reports progress lines to onProgress, offers other lines to
+// onLine and logs whatever onLine doesn't consume
+func progressWriter(onProgress func(Progress), onLine func(string) bool) *lineWriter {
+	var last Progress
+	return &lineWriter{line: func(line string) {
+		if p, ok := parseProgress(line, last); ok {
+			last = p
+			if onProgress != nil {
+				onProgress(p)
+			}
+			return
+		}
+		if onLine != nil && onLine(line) {
+			return
+		}
+		if line != "" {
+			log.Printf("yt-dlp: %s", line)
+		}
+	}}
+}
@@ -0,0 +1,219 @@
+// Package localmusic indexes a directory of audio files so they can be
+// searched and queued alongside YouTube results.
+package localmusic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// audioExts are the file types mpv can play that we index
+var audioExts = map[string]bool{
+	".mp3":  true,
+	".aac":  true,
+	".flac": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".m4a":  true,
+	".mp4":  true,
+	".wav":  true,
+}
+
+// IDPrefix starts the id of every local track, so they never collide with YouTube ids
+const IDPrefix = "local-"
+
+// Track is one audio file in the music directory
+type Track struct {
+	ID       string  `json:"id"`
+	URL      string  `json:"url"` // file:// URL of the file
+	Path     string  `json:"-"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+
+	words []string // Lowercased words of title, artist and album for search
+}
+
+type Library struct {
+	root   string
+	mu     sync.RWMutex
+	tracks []*Track // Sorted by artist, album, title
+}
+
+func New(root string) *Library {
+	return &Library{root: root}
+}
+
+// Scan walks the music directory and replaces the index.
+// Files whose tags can't be read are still indexed under their file name.
+func (l *Library) Scan() error {
+	root, err := filepath.Abs(l.root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve music dir: %w", err)
+	}
+
+	var tracks []*Track
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Skipping %s: %v", path, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !audioExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		tags, err := readTags(path)
+		if err != nil {
+			log.Printf("Failed to read tags from %s: %v", path, err)
+		}
+		tracks = append(tracks, newTrack(root, path, tags))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan music dir: %w", err)
+	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if !strings.EqualFold(a.Artist, b.Artist) {
+			return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+		}
+		if !strings.EqualFold(a.Album, b.Album) {
+			return strings.ToLower(a.Album) < strings.ToLower(b.Album)
+		}
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	})
+
+	l.mu.Lock()
+	l.tracks = tracks
+	l.mu.Unlock()
+
+	log.Printf("Indexed %d local tracks in %s", len(tracks), root)
+	return nil
+}
+
+// newTrack builds an index entry, falling back to "Artist - Title" file names
+func newTrack(root, path string, tags Tags) *Track {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	hash := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+
+	t := &Track{
+		ID:       IDPrefix + hex.EncodeToString(hash[:])[:12],
+		URL:      (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(),
+		Path:     path,
+		Title:    tags.Title,
+		Artist:   tags.Artist,
+		Album:    tags.Album,
+		Duration: tags.Duration,
+	}
+
+	if t.Title == "" {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if artist, title, ok := strings.Cut(name, " - "); ok && t.Artist == "" {
+			t.Artist, t.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+		} else {
+			t.Title = name
+		}
+	}
+	t.words = words(t.Title + " " + t.Artist + " " + t.Album)
+	if t.Artist == "" {
+		t.Artist = "Unknown Artist"
+	}
+	return t
+}
+
+// words splits s into lowercase letter and digit runs
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Search returns up to limit tracks where every word of the query starts a
+// word of the title, artist or album. Tracks matching whole words rank first.
+func (l *Library) Search(query string, limit int) []Track {
+	terms := words(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	type match struct {
+		track *Track
+		exact int
+	}
+	var matches []match
+	for _, t := range l.tracks {
+		exact := 0
+		found := true
+		for _, term := range terms {
+			hit := false
+			for _, w := range t.words {
+				if strings.HasPrefix(w, term) {
+					hit = true
+					if w == term {
+						exact++
+						break
+					}
+				}
+			}
+			if !hit {
+				found = false
+				break
+			}
+		}
+		if found {
+			matches = append(matches, match{t, exact})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].exact > matches[j].exact
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	results := make([]Track, len(matches))
+	for i, m := range matches {
+		results[i] = *m.track
+	}
+	return results
+}
+
+// Root returns the music directory
+func (l *Library) Root() string {
+	return l.root
+}
+
+// Len returns the number of indexed tracks
+func (l *Library) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.tracks)
+}
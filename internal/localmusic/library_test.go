@@ -0,0 +1,105 @@
+package localmusic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestLibrary scans a music dir holding files, keyed by slash separated path
+func newTestLibrary(t *testing.T, files map[string][]byte) *Library {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := New(root)
+	if err := l.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestScan(t *testing.T) {
+	tagged := id3Tag(3, 0,
+		id3Frame(3, "TIT2", 0, id3Text(0, "Zebra")),
+		id3Frame(3, "TPE1", 0, id3Text(0, "Abba")),
+		id3Frame(3, "TALB", 0, id3Text(0, "Gold")),
+	)
+	l := newTestLibrary(t, map[string][]byte{
+		"tagged.mp3":           tagged,
+		"Zappa - Peaches.mp3":  nil,
+		"sub/Intro.FLAC":       []byte("not flac"),
+		"sub/cover.jpg":        nil,
+		".hidden/Secret.mp3":   nil,
+		"notes.txt":            []byte("Abba - Zebra"),
+		"sub/deeper/Abba.opus": nil,
+	})
+
+	if l.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4", l.Len())
+	}
+
+	type entry struct{ Artist, Album, Title string }
+	var got []entry
+	for _, track := range l.tracks {
+		got = append(got, entry{track.Artist, track.Album, track.Title})
+	}
+	want := []entry{
+		{"Abba", "Gold", "Zebra"},
+		{"Unknown Artist", "", "Abba"},
+		{"Unknown Artist", "", "Intro"},
+		{"Zappa", "", "Peaches"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan() indexed %v, want %v", got, want)
+	}
+
+	track := l.tracks[0]
+	if track.ID[:len(IDPrefix)] != IDPrefix || track.URL != "file://"+filepath.ToSlash(track.Path) {
+		t.Errorf("track ID %q, URL %q", track.ID, track.URL)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	l := newTestLibrary(t, map[string][]byte{
+		"Abba - Waterloo.mp3":    nil,
+		"Abba - Water Song.mp3":  nil,
+		"Blur - Song 2.mp3":      nil,
+		"Watershed - Abbey.opus": nil,
+	})
+
+	titles := func(tracks []Track) []string {
+		var out []string
+		for _, t := range tracks {
+			out = append(out, t.Title)
+		}
+		return out
+	}
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"water", 0, []string{"Water Song", "Waterloo", "Abbey"}},
+		{"abba wat", 0, []string{"Water Song", "Waterloo"}},
+		{"SONG", 1, []string{"Water Song"}},
+		{"song 2", 0, []string{"Song 2"}},
+		{"abbot", 0, nil},
+		{" - ", 0, nil},
+	}
+	for _, tt := range tests {
+		if got := titles(l.Search(tt.query, tt.limit)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q, %d) = %q, want %q", tt.query, tt.limit, got, tt.want)
+		}
+	}
+}
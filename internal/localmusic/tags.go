@@ -0,0 +1,600 @@
+package localmusic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Size caps for metadata we load into memory. Text tags are tiny; anything
+// bigger is cover art or a broken file.
+const (
+	maxTextFrame = 1 << 20
+	maxComment   = 16 << 20
+	maxMoov      = 32 << 20
+)
+
+// Tags is the metadata read from an audio file
+type Tags struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration float64 // Seconds, 0 if unknown
+}
+
+// readTags reads whatever tags the file's container carries
+func readTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".aac":
+		return readMP3(f)
+	case ".flac":
+		return readFLAC(f)
+	case ".ogg", ".oga", ".opus":
+		return readOgg(f)
+	case ".m4a", ".mp4":
+		return readMP4(f)
+	}
+	return Tags{}, nil
+}
+
+// readMP3 reads ID3v2, falling back to ID3v1 for missing fields
+func readMP3(f *os.File) (Tags, error) {
+	t, _, err := readID3v2(f)
+	if err != nil {
+		return t, err
+	}
+	if t.Title == "" || t.Artist == "" {
+		v1 := readID3v1(f)
+		if t.Title == "" {
+			t.Title = v1.Title
+		}
+		if t.Artist == "" {
+			t.Artist = v1.Artist
+		}
+		if t.Album == "" {
+			t.Album = v1.Album
+		}
+	}
+	return t, nil
+}
+
+// readID3v2 parses an ID3v2.2-2.4 tag at the start of the file and returns
+// its total length so callers can skip it. Without a tag it returns length 0
+// and leaves the file at offset 0.
+func readID3v2(f io.ReadSeeker) (Tags, int64, error) {
+	var t Tags
+	var hdr [10]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[:3]) != "ID3" {
+		_, err := f.Seek(0, io.SeekStart)
+		return t, 0, err
+	}
+
+	version, flags := hdr[3], hdr[5]
+	end := 10 + int64(synchsafe(hdr[6:10]))
+	tagLen := end
+	if flags&0x10 != 0 {
+		tagLen += 10 // Footer
+	}
+
+	// Before 2.4 unsynchronisation covers the whole tag, so it is undone in
+	// memory and the frames are read from there
+	var r io.ReadSeeker = f
+	pos := int64(10)
+	if flags&0x80 != 0 && version < 4 {
+		if end-10 > maxComment {
+			return t, tagLen, nil
+		}
+		body := make([]byte, end-10)
+		if _, err := io.ReadFull(f, body); err != nil {
+			return t, tagLen, nil
+		}
+		body = deunsync(body)
+		r, pos, end = bytes.NewReader(body), 0, int64(len(body))
+	}
+
+	if flags&0x40 != 0 && version >= 3 {
+		var ext [4]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return t, tagLen, nil
+		}
+		if version == 4 {
+			pos += int64(synchsafe(ext[:])) // Includes the size field
+		} else {
+			pos += 4 + int64(binary.BigEndian.Uint32(ext[:]))
+		}
+	}
+
+	frameHdrLen := int64(10)
+	if version == 2 {
+		frameHdrLen = 6
+	}
+
+	for pos+frameHdrLen <= end {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return t, tagLen, err
+		}
+		fh := make([]byte, frameHdrLen)
+		if _, err := io.ReadFull(r, fh); err != nil {
+			break
+		}
+		if fh[0] == 0 {
+			break // Padding
+		}
+
+		var id string
+		var size int64
+		var frameFlags byte
+		switch version {
+		case 2:
+			id = string(fh[:3])
+			size = int64(fh[3])<<16 | int64(fh[4])<<8 | int64(fh[5])
+		case 4:
+			id = string(fh[:4])
+			size = int64(synchsafe(fh[4:8]))
+			frameFlags = fh[9]
+		default:
+			id = string(fh[:4])
+			size = int64(binary.BigEndian.Uint32(fh[4:8]))
+		}
+		pos += frameHdrLen
+		if pos+size > end {
+			break
+		}
+
+		var field *string
+		switch id {
+		case "TIT2", "TT2":
+			field = &t.Title
+		case "TPE1", "TP1":
+			field = &t.Artist
+		case "TALB", "TAL":
+			field = &t.Album
+		case "TLEN", "TLE":
+		default:
+			pos += size
+			continue
+		}
+
+		if size > 0 && size <= maxTextFrame {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				break
+			}
+			// 2.4 frames carry their own flags: a data length indicator
+			// in front, and unsynchronisation, per frame or set in the header
+			if frameFlags&0x01 != 0 && len(data) >= 4 {
+				data = data[4:]
+			}
+			if version == 4 && (flags&0x80 != 0 || frameFlags&0x02 != 0) {
+				data = deunsync(data)
+			}
+			text := decodeID3Text(data)
+			if field != nil {
+				*field = text
+			} else if ms, err := strconv.ParseFloat(text, 64); err == nil {
+				t.Duration = ms / 1000
+			}
+		}
+		pos += size
+	}
+
+	return t, tagLen, nil
+}
+
+// deunsync undoes ID3 unsynchronisation, which puts a 0 after every 0xFF.
+// It works in place.
+func deunsync(b []byte) []byte {
+	out := b[:0]
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// readID3v1 reads the fixed 128-byte tag at the end of the file
+func readID3v1(f io.ReadSeeker) Tags {
+	var tag [128]byte
+	if _, err := f.Seek(-128, io.SeekEnd); err != nil {
+		return Tags{}
+	}
+	if _, err := io.ReadFull(f, tag[:]); err != nil || string(tag[:3]) != "TAG" {
+		return Tags{}
+	}
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(latin1(b))
+	}
+	return Tags{
+		Title:  field(tag[3:33]),
+		Artist: field(tag[33:63]),
+		Album:  field(tag[63:93]),
+	}
+}
+
+func synchsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// decodeID3Text decodes a text frame body, which starts with an encoding byte
+func decodeID3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var s string
+	switch enc, data := b[0], b[1:]; enc {
+	case 0:
+		s = latin1(data)
+	case 1:
+		s = decodeUTF16(data, nil)
+	case 2:
+		s = decodeUTF16(data, binary.BigEndian)
+	default:
+		s = string(data)
+	}
+	// ID3v2.4 separates multiple values with NUL, keep the first
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// decodeUTF16 decodes UTF-16 text. A nil order means read it from the BOM.
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	if order == nil {
+		order = binary.LittleEndian
+		if len(b) >= 2 {
+			switch {
+			case b[0] == 0xfe && b[1] == 0xff:
+				order, b = binary.BigEndian, b[2:]
+			case b[0] == 0xff && b[1] == 0xfe:
+				b = b[2:]
+			}
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// readFLAC reads the STREAMINFO and VORBIS_COMMENT metadata blocks
+func readFLAC(f *os.File) (Tags, error) {
+	var t Tags
+
+	// Some taggers put an ID3v2 tag in front of the stream
+	_, skip, err := readID3v2(f)
+	if err != nil {
+		return t, err
+	}
+	if _, err := f.Seek(skip, io.SeekStart); err != nil {
+		return t, err
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return t, fmt.Errorf("not a FLAC stream")
+	}
+
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			return t, err
+		}
+		last := hdr[0]&0x80 != 0
+		blockType := hdr[0] & 0x7f
+		size := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+
+		switch {
+		case blockType == 0 && size >= 18:
+			info := make([]byte, size)
+			if _, err := io.ReadFull(f, info); err != nil {
+				return t, err
+			}
+			rate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+			samples := uint64(info[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+			if rate > 0 {
+				t.Duration = float64(samples) / float64(rate)
+			}
+		case blockType == 4 && size <= maxComment:
+			comment := make([]byte, size)
+			if _, err := io.ReadFull(f, comment); err != nil {
+				return t, err
+			}
+			parseVorbisComment(comment, &t)
+		default:
+			if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+				return t, err
+			}
+		}
+
+		if last {
+			return t, nil
+		}
+	}
+}
+
+// parseVorbisComment reads a Vorbis comment block, as used by FLAC, Ogg Vorbis and Opus
+func parseVorbisComment(b []byte, t *Tags) {
+	le := binary.LittleEndian
+	if len(b) < 4 {
+		return
+	}
+	vendorLen := uint64(le.Uint32(b))
+	b = b[4:]
+	if vendorLen > uint64(len(b)) {
+		return
+	}
+	b = b[vendorLen:]
+	if len(b) < 4 {
+		return
+	}
+	count := le.Uint32(b)
+	b = b[4:]
+
+	for i := uint32(0); i < count && len(b) >= 4; i++ {
+		n := uint64(le.Uint32(b))
+		b = b[4:]
+		if n > uint64(len(b)) {
+			return
+		}
+		key, value, ok := strings.Cut(string(b[:n]), "=")
+		b = b[n:]
+		if !ok {
+			continue
+		}
+
+		var field *string
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			field = &t.Title
+		case "ARTIST":
+			field = &t.Artist
+		case "ALBUM":
+			field = &t.Album
+		default:
+			continue
+		}
+		// Repeated fields are allowed, keep the first
+		if *field == "" {
+			*field = strings.TrimSpace(value)
+		}
+	}
+}
+
+// readOgg reads the identification and comment headers of an Ogg Vorbis or
+// Opus stream, and the duration from the granule position of the last page
+func readOgg(f *os.File) (Tags, error) {
+	var t Tags
+	r := bufio.NewReader(f)
+
+	// The first two packets are the headers; the comment one may span pages
+	var packets [][]byte
+	var packet []byte
+	for len(packets) < 2 {
+		var hdr [27]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return t, err
+		}
+		if string(hdr[:4]) != "OggS" {
+			return t, fmt.Errorf("not an Ogg stream")
+		}
+		segments := make([]byte, hdr[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return t, err
+		}
+		for _, n := range segments {
+			seg := make([]byte, n)
+			if _, err := io.ReadFull(r, seg); err != nil {
+				return t, err
+			}
+			packet = append(packet, seg...)
+			if n < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+		if len(packet) > maxComment {
+			return t, fmt.Errorf("ogg header too large")
+		}
+	}
+
+	ident, comment := packets[0], packets[1]
+	var rate, preSkip uint64
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		rate = uint64(binary.LittleEndian.Uint32(ident[12:16]))
+		if bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			parseVorbisComment(comment[7:], &t)
+		}
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 12:
+		rate = 48000 // Opus granule positions are always at 48kHz
+		preSkip = uint64(binary.LittleEndian.Uint16(ident[10:12]))
+		if bytes.HasPrefix(comment, []byte("OpusTags")) {
+			parseVorbisComment(comment[8:], &t)
+		}
+	default:
+		return t, fmt.Errorf("unsupported Ogg codec")
+	}
+
+	if granule, ok := lastGranule(f); ok && rate > 0 && granule > preSkip {
+		t.Duration = float64(granule-preSkip) / float64(rate)
+	}
+	return t, nil
+}
+
+// lastGranule finds the granule position of the last Ogg page
+func lastGranule(f *os.File) (uint64, bool) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false
+	}
+	n := min(info.Size(), 64<<10)
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, info.Size()-n); err != nil && err != io.EOF {
+		return 0, false
+	}
+	i := bytes.LastIndex(buf, []byte("OggS"))
+	if i < 0 || i+14 > len(buf) {
+		return 0, false
+	}
+	granule := binary.LittleEndian.Uint64(buf[i+6:])
+	if granule == ^uint64(0) {
+		return 0, false // No packet ends on this page
+	}
+	return granule, true
+}
+
+// readMP4 finds the moov atom and reads the duration from mvhd and the
+// iTunes-style tags from udta/meta/ilst
+func readMP4(f *os.File) (Tags, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+			return Tags{}, fmt.Errorf("no moov atom: %w", err)
+		}
+		size := uint64(binary.BigEndian.Uint32(hdr[:4]))
+		hdrLen := uint64(8)
+		if size == 1 {
+			var ext [8]byte
+			if _, err := io.ReadFull(f, ext[:]); err != nil {
+				return Tags{}, err
+			}
+			size = binary.BigEndian.Uint64(ext[:])
+			hdrLen = 16
+		}
+		if size == 0 {
+			return Tags{}, fmt.Errorf("no moov atom") // Last atom runs to the end of the file
+		}
+		if size < hdrLen {
+			return Tags{}, fmt.Errorf("invalid MP4 atom size")
+		}
+
+		if string(hdr[4:8]) == "moov" {
+			if size-hdrLen > maxMoov {
+				return Tags{}, fmt.Errorf("moov atom too large")
+			}
+			moov := make([]byte, size-hdrLen)
+			if _, err := io.ReadFull(f, moov); err != nil {
+				return Tags{}, err
+			}
+			return parseMoov(moov), nil
+		}
+		if _, err := f.Seek(int64(size-hdrLen), io.SeekCurrent); err != nil {
+			return Tags{}, err
+		}
+	}
+}
+
+// atoms calls fn for each child atom in data
+func atoms(data []byte, fn func(kind string, body []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		kind := string(data[4:8])
+		hdrLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdrLen = 16
+		}
+		if size < hdrLen || size > uint64(len(data)) {
+			return
+		}
+		fn(kind, data[hdrLen:size])
+		data = data[size:]
+	}
+}
+
+func parseMoov(moov []byte) Tags {
+	var t Tags
+	atoms(moov, func(kind string, body []byte) {
+		switch kind {
+		case "mvhd":
+			t.Duration = mvhdDuration(body)
+		case "udta":
+			atoms(body, func(kind string, body []byte) {
+				if kind == "meta" {
+					parseMeta(body, &t)
+				}
+			})
+		}
+	})
+	return t
+}
+
+func mvhdDuration(body []byte) float64 {
+	be := binary.BigEndian
+	var scale, duration uint64
+	switch {
+	case len(body) >= 32 && body[0] == 1:
+		scale, duration = uint64(be.Uint32(body[20:24])), be.Uint64(body[24:32])
+	case len(body) >= 20 && body[0] == 0:
+		scale, duration = uint64(be.Uint32(body[12:16])), uint64(be.Uint32(body[16:20]))
+	}
+	if scale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(scale)
+}
+
+func parseMeta(body []byte, t *Tags) {
+	// meta is a full atom in MP4 but not in QuickTime files
+	if len(body) >= 8 && string(body[4:8]) != "hdlr" {
+		body = body[4:]
+	}
+	atoms(body, func(kind string, ilst []byte) {
+		if kind != "ilst" {
+			return
+		}
+		atoms(ilst, func(kind string, item []byte) {
+			var field *string
+			switch kind {
+			case "\xa9nam":
+				field = &t.Title
+			case "\xa9ART":
+				field = &t.Artist
+			case "\xa9alb":
+				field = &t.Album
+			default:
+				return
+			}
+			atoms(item, func(kind string, data []byte) {
+				// Type indicator and locale come before the value
+				if kind == "data" && len(data) > 8 && *field == "" {
+					*field = strings.TrimSpace(string(data[8:]))
+				}
+			})
+		})
+	})
+}
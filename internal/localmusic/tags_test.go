@@ -0,0 +1,354 @@
+package localmusic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// synchsafeBytes encodes n in ID3's 7-bits-per-byte sizes
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// id3Text is a text frame body in the given ID3 encoding
+func id3Text(enc byte, s string) []byte {
+	switch enc {
+	case 0:
+		b := []byte{0}
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return b
+	case 1:
+		b := []byte{1, 0xff, 0xfe} // Little endian BOM
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+		return b
+	}
+	return append([]byte{enc}, s...)
+}
+
+// id3Frame builds a frame for an ID3v2.version tag
+func id3Frame(version byte, id string, flags byte, body []byte) []byte {
+	b := []byte(id)
+	switch version {
+	case 2:
+		b = append(b, byte(len(body)>>16), byte(len(body)>>8), byte(len(body)))
+		return append(b, body...)
+	case 4:
+		b = append(b, synchsafeBytes(len(body))...)
+	default:
+		b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	}
+	b = append(b, 0, flags)
+	return append(b, body...)
+}
+
+// id3Tag wraps frames in an ID3v2 header
+func id3Tag(version, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	return append(append([]byte{'I', 'D', '3', version, 0, flags}, synchsafeBytes(len(body))...), body...)
+}
+
+// unsync applies ID3 unsynchronisation
+func unsync(b []byte) []byte {
+	var out []byte
+	for _, c := range b {
+		out = append(out, c)
+		if c == 0xff {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func TestReadID3v2(t *testing.T) {
+	songTags := [][]byte{
+		id3Frame(3, "TIT2", 0, id3Text(0, "Song")),
+		id3Frame(3, "TPE1", 0, id3Text(1, "Bänd")),
+		id3Frame(3, "TALB", 0, id3Text(3, "Record")),
+		id3Frame(3, "TLEN", 0, id3Text(0, "215000")),
+	}
+	unsyncBody := unsync(bytes.Join(songTags, nil))
+	unsyncTag := append(append([]byte{'I', 'D', '3', 3, 0, 0x80}, synchsafeBytes(len(unsyncBody))...), unsyncBody...)
+
+	// A 2.4 frame with a data length indicator, unsynchronised on its own
+	v4Body := append(synchsafeBytes(7), unsync(id3Text(1, "ÿ"))...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Tags
+		wantLen int64
+	}{
+		{
+			name:    "v2.3",
+			data:    id3Tag(3, 0, songTags...),
+			want:    Tags{Title: "Song", Artist: "Bänd", Album: "Record", Duration: 215},
+			wantLen: int64(10 + len(bytes.Join(songTags, nil))),
+		},
+		{
+			name:    "v2.4 keeps the first of several values",
+			data:    id3Tag(4, 0, id3Frame(4, "TIT2", 0, id3Text(3, "Song\x00Other")), id3Frame(4, "TPE1", 0, id3Text(3, " Band "))),
+			want:    Tags{Title: "Song", Artist: "Band"},
+			wantLen: 10 + 2*10 + 12 + 6,
+		},
+		{
+			name:    "v2.2",
+			data:    id3Tag(2, 0, id3Frame(2, "TT2", 0, id3Text(0, "Song")), id3Frame(2, "TP1", 0, id3Text(0, "Band"))),
+			want:    Tags{Title: "Song", Artist: "Band"},
+			wantLen: 10 + 2*6 + 5 + 5,
+		},
+		{
+			name:    "v2.3 extended header",
+			data:    id3Tag(3, 0x40, append([]byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, id3Frame(3, "TIT2", 0, id3Text(0, "Song"))...)),
+			want:    Tags{Title: "Song"},
+			wantLen: 10 + 10 + 15,
+		},
+		{
+			name:    "v2.3 unsynchronised tag",
+			data:    unsyncTag,
+			want:    Tags{Title: "Song", Artist: "Bänd", Album: "Record", Duration: 215},
+			wantLen: int64(len(unsyncTag)),
+		},
+		{
+			name:    "v2.4 unsynchronised frame",
+			data:    id3Tag(4, 0, id3Frame(4, "TIT2", 0x03, v4Body)),
+			want:    Tags{Title: "ÿ"},
+			wantLen: int64(10 + 10 + len(v4Body)),
+		},
+		{
+			name: "no tag",
+			data: []byte("RIFF\x00\x00\x00\x00WAVE"),
+		},
+		{
+			name: "truncated header",
+			data: []byte("ID3\x03\x00"),
+		},
+		{
+			name:    "truncated frames",
+			data:    append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 100}, "TIT2\x00\x00"...),
+			wantLen: 110,
+		},
+		{
+			name: "frame longer than the tag",
+			data: id3Tag(3, 0,
+				id3Frame(3, "TIT2", 0, id3Text(0, "Song")),
+				[]byte("TPE1\x7f\xff\xff\xff\x00\x00Band"),
+			),
+			want:    Tags{Title: "Song"},
+			wantLen: 10 + 15 + 14,
+		},
+		{
+			name: "frame too big to load",
+			data: append(
+				[]byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f},
+				append(id3Frame(3, "TIT2", 0, id3Text(0, "Song")), "TPE1\x01\x00\x00\x00\x00\x00Band"...)...,
+			),
+			want:    Tags{Title: "Song"},
+			wantLen: 10 + 0x0fffffff,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n, err := readID3v2(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || n != tt.wantLen {
+				t.Errorf("readID3v2() = %+v, %d; want %+v, %d", got, n, tt.want, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestDeunsync(t *testing.T) {
+	tests := []struct{ in, want []byte }{
+		{[]byte{0xff, 0x00, 0xfe}, []byte{0xff, 0xfe}},
+		{[]byte{0xff, 0x00, 0x00}, []byte{0xff, 0x00}},
+		{[]byte{0xff, 0xe0}, []byte{0xff, 0xe0}},
+		{[]byte{0x01, 0xff}, []byte{0x01, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := deunsync(bytes.Clone(tt.in)); !bytes.Equal(got, tt.want) {
+			t.Errorf("deunsync(% x) = % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
+
+// vorbisComment builds a Vorbis comment block
+func vorbisComment(fields ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 6)
+	b = append(b, "vendor"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(fields)))
+	for _, f := range fields {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+// flacBlock builds a FLAC metadata block header and body
+func flacBlock(blockType byte, last bool, body []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	return append([]byte{blockType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// streamInfo is a FLAC STREAMINFO body for samples at rate Hz
+func streamInfo(rate, samples int) []byte {
+	info := make([]byte, 34)
+	info[10] = byte(rate >> 12)
+	info[11] = byte(rate >> 4)
+	info[12] = byte(rate<<4) | 0x02 // Low bits: channels
+	binary.BigEndian.PutUint32(info[14:18], uint32(samples))
+	return info
+}
+
+// atom builds an MP4 atom
+func atom(kind string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), kind...), body...)
+}
+
+// oggPage builds an Ogg page holding one packet shorter than 255 bytes
+func oggPage(granule uint64, packet []byte) []byte {
+	b := append([]byte("OggS"), 0, 0)
+	b = binary.LittleEndian.AppendUint64(b, granule)
+	b = append(b, make([]byte, 12)...) // Serial, sequence and CRC, not checked
+	b = append(b, 1, byte(len(packet)))
+	return append(b, packet...)
+}
+
+func TestReadTags(t *testing.T) {
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], "Old title")
+	copy(id3v1[33:], "Old band")
+
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 5500)
+
+	opusHead := append([]byte("OpusHead\x01\x02"), 0x38, 0x01) // Pre-skip 312
+
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    Tags
+		wantErr bool
+	}{
+		{
+			name: "mp3 falls back to ID3v1",
+			file: "a.mp3",
+			data: append(append(id3Tag(3, 0, id3Frame(3, "TIT2", 0, id3Text(0, "New title"))), "audio"...), id3v1...),
+			want: Tags{Title: "New title", Artist: "Old band"},
+		},
+		{
+			name: "flac",
+			file: "a.flac",
+			data: append([]byte("fLaC"), append(
+				flacBlock(0, false, streamInfo(44100, 441000)),
+				flacBlock(4, true, vorbisComment("title=Song", "ARTIST=Band", "ARTIST=Other", "ALBUM=Record", "junk"))...,
+			)...),
+			want: Tags{Title: "Song", Artist: "Band", Album: "Record", Duration: 10},
+		},
+		{
+			name: "flac behind an ID3 tag",
+			file: "a.flac",
+			data: append(id3Tag(3, 0, id3Frame(3, "TIT2", 0, id3Text(0, "Ignored"))),
+				append([]byte("fLaC"), flacBlock(4, true, vorbisComment("TITLE=Song"))...)...),
+			want: Tags{Title: "Song"},
+		},
+		{
+			name: "flac comment with an oversized field",
+			file: "a.flac",
+			data: append([]byte("fLaC"), flacBlock(4, true,
+				append(vorbisComment("TITLE=Song", "ARTIST=Band"), 0xff, 0xff, 0xff, 0x7f, 'x'))...),
+			want: Tags{Title: "Song", Artist: "Band"},
+		},
+		{
+			name:    "truncated flac",
+			file:    "a.flac",
+			data:    []byte("fLaC\x84\x00\x01"),
+			wantErr: true,
+		},
+		{
+			name:    "not flac",
+			file:    "a.flac",
+			data:    []byte("OggS"),
+			wantErr: true,
+		},
+		{
+			name: "m4a",
+			file: "a.m4a",
+			data: append(atom("ftyp", []byte("M4A ")), atom("moov",
+				atom("mvhd", mvhd),
+				atom("udta", atom("meta", make([]byte, 4), atom("ilst",
+					atom("\xa9nam", atom("data", make([]byte, 8), []byte("Song"))),
+					atom("\xa9ART", atom("data", make([]byte, 8), []byte("Band"))),
+				))),
+			)...),
+			want: Tags{Title: "Song", Artist: "Band", Duration: 5.5},
+		},
+		{
+			name:    "m4a with a bad atom size",
+			file:    "a.m4a",
+			data:    []byte("\x00\x00\x00\x04ftyp"),
+			wantErr: true,
+		},
+		{
+			name:    "m4a without moov",
+			file:    "a.m4a",
+			data:    atom("ftyp", []byte("M4A ")),
+			wantErr: true,
+		},
+		{
+			name: "opus",
+			file: "a.opus",
+			data: append(oggPage(0, opusHead), oggPage(3*48000+312, append([]byte("OpusTags"), vorbisComment("TITLE=Song")...))...),
+			want: Tags{Title: "Song", Duration: 3},
+		},
+		{
+			name:    "truncated ogg",
+			file:    "a.ogg",
+			data:    oggPage(0, opusHead)[:30],
+			wantErr: true,
+		},
+		{
+			name: "untagged format",
+			file: "a.wav",
+			data: []byte("RIFF"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readTags(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("readTags() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("readTags() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
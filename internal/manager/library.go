@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/youtube"
+	"log"
+)
+
+// ErrNotCached is returned when a library track is no longer in the download cache
+var ErrNotCached = errors.New("track not in cache")
+
+// Library returns every track in the download cache
+func (m *Manager) Library() []downloader.Entry {
+	return m.downloader.Entries()
+}
+
+// AddCached queues tracks straight from the download cache and returns their
+// queue ids. They are ready at once and never touch yt-dlp or the network.
+// With play, the first one starts immediately. Nothing is queued if any id is
+// missing from the cache.
+func (m *Manager) AddCached(ids []string, play bool) ([]string, error) {
+	entries := make([]downloader.Entry, len(ids))
+	for i, id := range ids {
+		e, ok := m.downloader.Lookup(id)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotCached, id)
+		}
+		entries[i] = e
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	items := make([]*QueueItem, len(entries))
+	for i, e := range entries {
+		title := e.Title
+		if title == "" {
+			title = e.ID
+		}
+		url := e.SourceURL
+		if url == "" {
+			// Rebuilt from a directory scan, where the file name is all there is
+			url = youtube.WatchURL(e.ID)
+		}
+		item := m.newItem(url, title, e.ID, e.Artist)
+		item.LocalPath = e.Path
+		item.Status = StatusReady
+		m.queue = append(m.queue, item)
+		m.orderAdd(item, play && i == 0)
+		items[i] = item
+	}
+
+	if play {
+		if err := m.playItem(items[0]); err != nil {
+			log.Printf("Failed to play %s: %v", items[0].Title, err)
+			m.advance(items[0], 1, false)
+		}
+	}
+	queueIDs := make([]string, len(items))
+	for i, item := range items {
+		queueIDs[i] = item.QueueID
+	}
+	m.mu.Unlock()
+
+	m.notify(ChangeQueue)
+	m.notify(ChangeStatus)
+	return queueIDs, nil
+}
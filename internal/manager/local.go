@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrLocalFileDenied is returned for a file:// URL outside the directories
+// local files may be played from
+var ErrLocalFileDenied = errors.New("local file outside the music directory")
+
+// AddLocalDir allows file:// URLs pointing into dir. The download cache is
+// always allowed; anything else on the host is refused, so API clients can't
+// play or probe arbitrary files.
+func (m *Manager) AddLocalDir(dir string) {
+	resolved := resolvePath(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.localDirs = append(m.localDirs, resolved)
+}
+
+// CheckURL returns ErrLocalFileDenied for a file:// URL that may not be
+// queued, and nil for anything else
+func (m *Manager) CheckURL(rawURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, err := m.localFile(rawURL)
+	return err
+}
+
+// localFile resolves a file:// URL. ok is false for any other URL; err is
+// ErrLocalFileDenied if the file is outside the allowed directories.
+// Symlinks are followed before checking, so one can't point outside either.
+// m.mu must be locked.
+func (m *Manager) localFile(rawURL string) (path string, ok bool, err error) {
+	path, ok = localPath(rawURL)
+	if !ok {
+		return "", false, nil
+	}
+	path = resolvePath(path)
+	for _, dir := range m.localDirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path, true, nil
+		}
+	}
+	return "", true, ErrLocalFileDenied
+}
+
+// localPath returns the file a file:// URL points at
+func localPath(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Path), true
+}
+
+// resolvePath makes path absolute and follows symlinks, as far as it exists
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// fileMissing reports whether there is no file at path
+func fileMissing(path string) bool {
+	_, err := os.Stat(path)
+	return err != nil
+}
@@ -1,14 +1,18 @@
 package manager
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"kaboomer/internal/downloader"
 	"kaboomer/internal/player"
 	"kaboomer/internal/youtube"
 	"log"
+	mrand "math/rand/v2"
+	"slices"
 	"sync"
+	"time"
 )
 
 type TrackStatus string
@@ -23,6 +27,7 @@ const (
 )
 
 type QueueItem struct {
+	QueueID   string      `json:"queue_id"` // Unique per queue entry, stable across restarts
 	ID        string      `json:"id"`
 	URL       string      `json:"url"`
 	Title     string      `json:"title"`
@@ -30,85 +35,334 @@ type QueueItem struct {
 	Status    TrackStatus `json:"status"`
 	LocalPath string      `json:"-"`
 	Error     string      `json:"error,omitempty"`
+	Attempts  int         `json:"attempts,omitempty"` // Downloads tried since the last success or manual retry
+	RetryAt   *time.Time  `json:"retry_at,omitempty"` // When a failed download is tried again, see retry.go
+
+	Progress *downloader.Progress `json:"progress,omitempty"` // Set while downloading
+
+	entryID int         // mpv playlist entry id of the last load, 0 until loaded
+	retry   *time.Timer // Pending automatic retry
+}
+
+// playable reports whether the item's file is on disk and can be handed to mpv
+func (item *QueueItem) playable() bool {
+	return item.Status == StatusReady || item.Status == StatusPlaying || item.Status == StatusPlayed
+}
+
+// progressNotifyInterval throttles notifications for download progress
+const progressNotifyInterval = time.Second
+
+// ChangeKind tells subscribers which part of the playback state changed
+type ChangeKind string
+
+const (
+	ChangeStatus ChangeKind = "status"
+	ChangeQueue  ChangeKind = "queue"
+	// ChangeProgress is a queue change that only moved download progress,
+	// which isn't persisted
+	ChangeProgress ChangeKind = "progress"
+)
+
+// Player is the mpv control the manager needs. *player.Player implements it,
+// tests attach one to a fake mpv from player/mpvtest.
+type Player interface {
+	Load(url, title string, start float64) (int, error)
+	StopPlayback() error
+	Pause() error
+	SetPaused(paused bool) error
+	Seek(seconds float64) error
+	SetVolume(volume float64) error
+	SetGain(db float64) error
+	SetFade(length, end float64) error
+	Preload(url, title string) (int, error)
+	ClearPreload() error
+	GetStatus() string
+	GetProperty(prop string) (interface{}, error)
+	Observed(prop string) (interface{}, bool)
+	Subscribe() (<-chan player.Event, func())
+	Health() player.Health
 }
 
 type Manager struct {
-	player     *player.Player
+	player     Player
 	downloader *downloader.Downloader
 	yt         *youtube.Service // Helper for ID extraction if needed
 	queue      []*QueueItem
 	mu         sync.Mutex
 
-	downloadChan chan *QueueItem
-	playTarget   *QueueItem // If set, play this immediately when ready
+	downloads  *scheduler
+	playTarget *QueueItem // If set, play this immediately when ready
+	current    *QueueItem // Item loaded in mpv (or last played); the manager owns the play order
+	preloaded  *QueueItem // Item queued in mpv to follow current in gapless mode
+	statePath  string     // Where the queue is persisted, empty until RestoreState
+	localDirs  []string   // Where file:// URLs may point, resolved; see local.go
+
+	retryPolicy   RetryPolicy
+	lastPlayback  playbackSnapshot // See recovery.go
+	saveMu        sync.Mutex       // Serialises SaveState
+	lastSaved     *savedState      // What SaveState last wrote, guarded by saveMu
+	normalization Normalization
+	transition    Transition
+
+	mode         PlayMode
+	shuffleSeed  uint64
+	shuffleOrder []*QueueItem // Play order in shuffle mode, a permutation of queue
+	shuffleRand  *mrand.Rand
+
+	listenersMu sync.Mutex
+	listeners   map[int]*listener
+	nextListen  int
 }
 
-func New(p *player.Player, d *downloader.Downloader, yt *youtube.Service) *Manager {
+func New(p Player, d *downloader.Downloader, yt *youtube.Service) *Manager {
 	m := &Manager{
-		player:       p,
-		downloader:   d,
-		yt:           yt,
-		queue:        make([]*QueueItem, 0),
-		downloadChan: make(chan *QueueItem, 100), // Buffer
+		player:     p,
+		downloader: d,
+		yt:         yt,
+		queue:      make([]*QueueItem, 0),
+		mode:       ModeOff,
+		listeners:  make(map[int]*listener),
+		localDirs:  []string{resolvePath(d.CacheDir())},
+
+		retryPolicy:   DefaultRetryPolicy,
+		normalization: DefaultNormalization,
 	}
+	m.downloads = newScheduler(1, m.processItem)
 
 	// Start background workers
-	go m.downloadWorker()
+	go m.downloads.loop()
+	go m.eventWorker()
+	go m.trackPlayback()
 
 	return m
 }
 
-func (m *Manager) downloadWorker() {
-	for item := range m.downloadChan {
-		m.processItem(item)
+// eventWorker follows mpv events to keep the current item authoritative
+// and turns them into status change notifications
+func (m *Manager) eventWorker() {
+	events, _ := m.player.Subscribe()
+	for ev := range events {
+		switch ev.Type {
+		case player.EventStartFile:
+			m.handleStartFile(ev)
+		case player.EventEndFile:
+			m.handleEndFile(ev)
+		case player.EventRestart:
+			m.handleRestart()
+		case player.EventPropertyChange:
+			if ev.Property == "duration" {
+				m.handleDuration(ev.Data)
+			}
+		}
+		m.notify(ChangeStatus)
+	}
+}
+
+// indexOf returns the queue position of item, or -1. m.mu must be locked.
+func (m *Manager) indexOf(item *QueueItem) int {
+	for i, queued := range m.queue {
+		if queued == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// Subscribe returns a channel that receives a ChangeKind whenever the
+// playback status or the queue changes, and a function to unsubscribe.
+// Notifications are coalesced per kind: a slow reader may see one change for
+// several, but never misses a kind that changed since it last read.
+func (m *Manager) Subscribe() (<-chan ChangeKind, func()) {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+
+	id := m.nextListen
+	m.nextListen++
+	l := &listener{
+		wake: make(chan struct{}, 1),
+		out:  make(chan ChangeKind),
+		done: make(chan struct{}),
+	}
+	m.listeners[id] = l
+	go l.deliver()
+
+	var once sync.Once
+	return l.out, func() {
+		once.Do(func() {
+			m.listenersMu.Lock()
+			delete(m.listeners, id)
+			m.listenersMu.Unlock()
+			close(l.done)
+		})
+	}
+}
+
+// notify tells every subscriber about a change. It never blocks, so it is
+// safe to call with m.mu held.
+func (m *Manager) notify(kind ChangeKind) {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	for _, l := range m.listeners {
+		l.mark(kind)
+	}
+}
+
+// listener holds the change kinds one subscriber hasn't received yet
+type listener struct {
+	mu      sync.Mutex
+	pending []ChangeKind // Each kind at most once, oldest first
+	wake    chan struct{}
+	out     chan ChangeKind
+	done    chan struct{} // Closed on unsubscribe
+}
+
+// mark records a change and wakes deliver without blocking
+func (l *listener) mark(kind ChangeKind) {
+	l.mu.Lock()
+	if !slices.Contains(l.pending, kind) {
+		l.pending = append(l.pending, kind)
+	}
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+		// Already woken, deliver picks this up with the rest
+	}
+}
+
+// deliver hands pending changes to the subscriber until it unsubscribes,
+// then closes its channel
+func (l *listener) deliver() {
+	defer close(l.out)
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.wake:
+		}
+
+		l.mu.Lock()
+		pending := l.pending
+		l.pending = nil
+		l.mu.Unlock()
+
+		for _, kind := range pending {
+			select {
+			case l.out <- kind:
+			case <-l.done:
+				return
+			}
+		}
+	}
+}
+
+// SetDownloadConcurrency sets how many downloads may run at once
+func (m *Manager) SetDownloadConcurrency(n int) {
+	m.downloads.setLimit(n)
+}
+
+// enqueue schedules a download for item. Play targets jump the queue, followed
+// by the item that plays next. m.mu must be locked.
+func (m *Manager) enqueue(item *QueueItem) {
+	if item.Status != StatusPending {
+		return // Local files and cached tracks need no download
+	}
+	priority := priorityQueued
+	switch {
+	case item == m.playTarget:
+		priority = priorityPlayNow
+	case m.current != nil && m.upNext() == item:
+		priority = priorityNextUp
+	}
+	m.downloads.push(item, priority)
+}
+
+// upNext returns the item after the current one in the play order, or nil.
+// m.mu must be locked.
+func (m *Manager) upNext() *QueueItem {
+	order := m.playOrder()
+	pos := orderIndex(order, m.current)
+	if pos == -1 || pos+1 >= len(order) {
+		return nil
 	}
+	return order[pos+1]
 }
 
-func (m *Manager) processItem(item *QueueItem) {
+func (m *Manager) processItem(ctx context.Context, item *QueueItem) {
 	// Double check status
 	m.mu.Lock()
-	if item.Status != StatusPending {
+	if item.Status != StatusPending || m.indexOf(item) == -1 {
+		// Already handled, or removed from the queue while waiting
+		m.mu.Unlock()
+		return
+	}
+	if _, ok := localPath(item.URL); ok {
+		// Only reachable after a restore found the file gone
+		item.Status = StatusError
+		item.Error = "local file not found"
 		m.mu.Unlock()
+		m.notify(ChangeQueue)
 		return
 	}
 	item.Status = StatusDownloading
+	item.Attempts++
+	track := downloader.Track{ID: item.ID, URL: item.URL, Title: item.Title, Artist: item.Artist}
 	m.mu.Unlock()
+	m.notify(ChangeQueue)
 
 	// Download
-	path, err := m.downloader.Download(item.URL, item.ID)
+	var lastNotify time.Time
+	path, err := m.downloader.Download(ctx, track, func(p downloader.Progress) {
+		m.mu.Lock()
+		item.Progress = &p
+		m.mu.Unlock()
+
+		// yt-dlp reports several times a second, the UI doesn't need all of them
+		if time.Since(lastNotify) >= progressNotifyInterval {
+			lastNotify = time.Now()
+			m.notify(ChangeProgress)
+		}
+	})
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	defer m.notify(ChangeStatus)
+	defer m.notify(ChangeQueue)
+
+	item.Progress = nil
+
+	if ctx.Err() != nil {
+		// Removed from the queue mid-download
+		log.Printf("Download cancelled: %s", item.Title)
+		item.Status = StatusPending
+		item.Attempts--
+		return
+	}
 
 	if err != nil {
 		log.Printf("Error downloading %s: %v", item.Title, err)
-		item.Status = StatusError
-		item.Error = err.Error()
+		m.failDownload(item, err)
 
 		if m.playTarget == item {
-			m.playNextAvailable(item)
+			m.playTarget = nil
+			m.advance(item, 1, false)
 		}
 		return
 	}
 
 	item.LocalPath = path
 	item.Status = StatusReady
+	item.Error = ""
+	item.Attempts = 0
+	go m.PruneCache()
 
-	// Logic to play or append
 	if m.playTarget == item {
 		// This was requested to play immediately
 		log.Printf("PlayTarget ready: %s", item.Title)
-		if err := m.player.Play(item.LocalPath, item.Title); err != nil {
+		m.playTarget = nil
+		if err := m.playItem(item); err != nil {
 			log.Printf("Failed to play %s: %v", item.Title, err)
-			m.playNextAvailable(item)
-		} else {
-			m.playTarget = nil
-		}
-	} else {
-		// Just append to playlist
-		log.Printf("Appending to playlist: %s", item.Title)
-		if err := m.player.Append(item.LocalPath, item.Title); err != nil {
-			log.Printf("Failed to append %s: %v", item.Title, err)
+			m.advance(item, 1, false)
 		}
 	}
 }
@@ -124,19 +378,31 @@ func (m *Manager) ClearQueue() {
 	// then Next() will find nothing. This is reasonable.
 
 	// However, we should keep the current playing item if possible so the UI doesn't break
-	// and so Next/Prev logic (which relies on finding current path in queue) doesn't break.
-
-	// Find current playing item
-	pathProp, err := m.player.GetProperty("path")
-	var currentItem *QueueItem
-	if err == nil {
-		if currentPath, ok := pathProp.(string); ok && currentPath != "" {
-			for _, item := range m.queue {
-				if item.LocalPath == currentPath {
-					currentItem = item
-					break
-				}
-			}
+	// and so Next/Prev logic (which relies on finding the current item in queue) doesn't break.
+	m.clearQueue(m.current)
+}
+
+// ClearAll empties the whole queue, the current item included, and stops
+// playback. It is for replacing the queue with something else.
+func (m *Manager) ClearAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil {
+		if err := m.player.StopPlayback(); err != nil {
+			log.Printf("Failed to stop %s: %v", m.current.Title, err)
+		}
+		m.current = nil
+	}
+	m.clearQueue(nil)
+	m.notify(ChangeStatus)
+}
+
+// clearQueue drops every item but currentItem, if not nil. m.mu must be locked.
+func (m *Manager) clearQueue(currentItem *QueueItem) {
+	for _, item := range m.queue {
+		if item != currentItem {
+			m.downloads.cancel(item)
 		}
 	}
 
@@ -147,11 +413,16 @@ func (m *Manager) ClearQueue() {
 		// Clear all
 		m.queue = make([]*QueueItem, 0)
 	}
+	if m.mode == ModeShuffle {
+		m.shuffleOrder = append([]*QueueItem(nil), m.queue...)
+	}
 
 	// Also clear playTarget if it's not the current item
 	if m.playTarget != currentItem {
 		m.playTarget = nil
 	}
+	m.syncPreload()
+	m.notify(ChangeQueue)
 }
 
 // ensureID ensures the item has an ID. If not, generates one or extracts it.
@@ -169,254 +440,153 @@ func (m *Manager) ensureID(url, id string) string {
 	return hex.EncodeToString(hash[:])[:12]
 }
 
-func (m *Manager) Add(url, title, id, artist string) {
-	m.mu.Lock()
-	id = m.ensureID(url, id)
+// newItem creates a queue item with a fresh queue id. It is pending, unless
+// it is a local file, which is ready at once.
+func (m *Manager) newItem(url, title, id, artist string) *QueueItem {
 	item := &QueueItem{
-		ID:     id,
-		URL:    url,
-		Title:  title,
-		Artist: artist,
-		Status: StatusPending,
+		QueueID: newQueueID(),
+		ID:      m.ensureID(url, id),
+		URL:     url,
+		Title:   title,
+		Artist:  artist,
+		Status:  StatusPending,
 	}
-	m.queue = append(m.queue, item)
-	m.mu.Unlock()
+	if path, ok, err := m.localFile(url); ok {
+		item.Status = StatusReady
+		switch {
+		case err != nil:
+			// Not even checked for existence, that would tell what's on the host
+			item.Status = StatusError
+			item.Error = err.Error()
+		case fileMissing(path):
+			item.Status = StatusError
+			item.Error = "local file not found"
+		default:
+			item.LocalPath = path
+		}
+	}
+	return item
+}
 
-	// Trigger download
-	m.downloadChan <- item
+// newQueueID returns a random id that tells apart repeated entries of the same track
+func newQueueID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(b[:])
 }
 
-func (m *Manager) Play(url, title, id, artist string) {
+func (m *Manager) Add(url, title, id, artist string) {
 	m.mu.Lock()
-	id = m.ensureID(url, id)
-	item := &QueueItem{
-		ID:     id,
-		URL:    url,
-		Title:  title,
-		Artist: artist,
-		Status: StatusPending,
-	}
-	// Add to end (or replace? user might want history, let's just append)
+	item := m.newItem(url, title, id, artist)
 	m.queue = append(m.queue, item)
-
-	// Set as target
-	m.playTarget = item
+	m.orderAdd(item, false)
+	m.enqueue(item)
 	m.mu.Unlock()
-
-	// Trigger download (prioritize?)
-	// Since channel is buffered, we can't easily jump queue unless we use a priority queue.
-	// But usually queue is empty-ish.
-	// Ideally we'd push to front of channel?
-	// For simplicity, we just push to channel. If channel has backlog, this waits.
-	// Optimization: We could have a separate "high priority" channel or method.
-	// But let's assume valid usage.
-	m.downloadChan <- item
+	m.notify(ChangeQueue)
 }
 
-// GetQueue returns the current queue state
-func (m *Manager) GetQueue() []*QueueItem {
+func (m *Manager) Play(url, title, id, artist string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	// Return copy to be safe?
-	cp := make([]*QueueItem, len(m.queue))
-	copy(cp, m.queue)
-	return cp
-}
+	item := m.newItem(url, title, id, artist)
+	// Add to end (or replace? user might want history, let's just append)
+	m.queue = append(m.queue, item)
+	m.orderAdd(item, true)
 
-// GetPlayTarget returns the current item targeted for playback (e.g. buffering/downloading)
-func (m *Manager) GetPlayTarget() *QueueItem {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	return m.playTarget
+	// Plays now if ready, otherwise its download jumps ahead of everything queued
+	if err := m.startItem(item); err != nil {
+		log.Printf("Failed to play %s: %v", item.Title, err)
+	}
+	m.mu.Unlock()
+	m.notify(ChangeQueue)
+	m.notify(ChangeStatus)
 }
 
-// Control Passthroughs
-// func (m *Manager) Next() error                                  { return m.player.Next() }
-// func (m *Manager) Prev() error                                  { return m.player.Prev() }
-func (m *Manager) Pause() error                                 { return m.player.Pause() }
-func (m *Manager) Seek(val float64) error                       { return m.player.Seek(val) }
-func (m *Manager) SetVolume(val float64) error                  { return m.player.SetVolume(val) }
-func (m *Manager) GetStatus() string                            { return m.player.GetStatus() }
-func (m *Manager) GetProperty(prop string) (interface{}, error) { return m.player.GetProperty(prop) }
-
-// Next plays the next item in the queue relative to the current one
-func (m *Manager) Next() error {
+// PruneCache evicts least recently played cached files that aren't in the queue
+func (m *Manager) PruneCache() (downloader.PruneResult, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	// Get current playing file path
-	pathProp, err := m.player.GetProperty("path")
-	if err != nil {
-		// Fallback to blind next
-		return m.player.Next()
-	}
-	currentPath, ok := pathProp.(string)
-	if !ok || currentPath == "" {
-		return m.player.Next()
-	}
-
-	// Find in queue
-	idx := -1
-	for i, item := range m.queue {
-		if item.LocalPath == currentPath {
-			idx = i
-			break
+	keep := make(map[string]bool, len(m.queue))
+	for _, item := range m.queue {
+		// Pending items have no LocalPath yet, but may be about to use a cached file
+		if entry, ok := m.downloader.Lookup(item.ID); ok {
+			keep[entry.Path] = true
 		}
 	}
+	m.mu.Unlock()
 
-	if idx == -1 {
-		// Not found, maybe played external file?
-		return m.player.Next()
+	result, err := m.downloader.Prune(keep)
+	if err != nil {
+		log.Printf("Cache prune error: %v", err)
 	}
+	return result, err
+}
 
-	// Find next valid item
-	nextIdx := idx + 1
-	if nextIdx >= len(m.queue) {
-		return nil // End of queue
-	}
+// CacheUsage reports the download cache size against its budget
+func (m *Manager) CacheUsage() (downloader.CacheUsage, error) {
+	return m.downloader.Usage()
+}
 
-	// We have a target index. Use internal logic to play it.
-	// We need to release lock to call PlayIndex if we want to reuse it,
-	// but PlayIndex takes lock. So we must be careful.
-	// Actually PlayIndex logic is simple enough to inline or use helper.
-	// Let's unlock and call PlayIndex.
-	m.mu.Unlock()
-	err = m.PlayIndex(nextIdx)
+// GetQueue returns a copy of every queue item
+func (m *Manager) GetQueue() []QueueItem {
 	m.mu.Lock()
-	return err
+	defer m.mu.Unlock()
+	return m.snapshot()
 }
 
-// Prev plays the previous item in the queue
-func (m *Manager) Prev() error {
+// GetQueueState returns the queue together with the index of the current item (-1 if none),
+// taken under a single lock so the two agree
+func (m *Manager) GetQueueState() ([]QueueItem, int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.snapshot(), m.currentIndex()
+}
 
-	// Get current playing file path
-	pathProp, err := m.player.GetProperty("path")
-	if err != nil {
-		return m.player.Prev()
-	}
-	currentPath, ok := pathProp.(string)
-	if !ok || currentPath == "" {
-		return m.player.Prev()
-	}
-
-	// Find in queue
-	idx := -1
+// snapshot copies every queue item. Downloads and retries keep writing to the
+// items, so nothing outside the manager may read them without m.mu. Progress
+// and RetryAt are replaced rather than changed in place, so the copies can
+// share them. m.mu must be locked.
+func (m *Manager) snapshot() []QueueItem {
+	cp := make([]QueueItem, len(m.queue))
 	for i, item := range m.queue {
-		if item.LocalPath == currentPath {
-			idx = i
-			break
-		}
-	}
-
-	if idx == -1 {
-		return m.player.Prev()
-	}
-
-	// Find prev valid item
-	prevIdx := idx - 1
-	if prevIdx < 0 {
-		return nil // Start of queue
+		cp[i] = *item
 	}
+	return cp
+}
 
-	m.mu.Unlock()
-	err = m.PlayIndex(prevIdx)
-	m.mu.Lock()
-	return err
-}
-
-// PlayIndex plays an item from the queue
-// This is tricky because mpv index might differ from our queue index
-// if we cleaned up. But currently we don't cleanup.
-// Also mpv playlist contains ONLY items that have been appended (Ready).
-// Pending items are not in mpv playlist.
-// So we can't map index 1:1 easily if there are Pending items in the middle.
-// BUT, the UI usually shows what is returned by GetQueue.
-// If UI calls PlayIndex(5), and item 5 is Pending, we can't play it yet.
-// We should check status.
-func (m *Manager) PlayIndex(index int) error {
+// GetCurrent returns a copy of the item mpv is playing (or last played)
+// and its queue index. ok is false when there is no current item.
+func (m *Manager) GetCurrent() (item QueueItem, index int, ok bool) {
 	m.mu.Lock()
-	if index < 0 || index >= len(m.queue) {
-		m.mu.Unlock()
-		return fmt.Errorf("index out of bounds")
-	}
-	item := m.queue[index]
-	m.mu.Unlock()
-
-	if item.Status == StatusReady || item.Status == StatusPlayed {
-		// It is likely in mpv. But where?
-		// mpv playlist indices shift if we remove things. We don't remove.
-		// BUT items are appended to mpv ONLY when Ready.
-		// So mpv list is a subset of m.queue (filtered by Ready).
-		// We can't rely on index matching.
-		// We'd need to find the item in mpv playlist by filename/title.
-		// This is getting complicated.
-
-		// Alternative: Clear mpv and play this file?
-		// Or: Don't implement PlayIndex for now, or assume sync.
-		// Simplest: Just call player.Play(item.LocalPath) which appends and plays.
-		// But that duplicates the entry in mpv.
-
-		if err := m.player.Play(item.LocalPath, item.Title); err != nil {
-			log.Printf("Failed to play %s: %v", item.Title, err)
-			m.mu.Lock()
-			m.playNextAvailable(item)
-			m.mu.Unlock()
-			return nil
-		}
-		return nil
-	} else if item.Status == StatusPending || item.Status == StatusDownloading {
-		// Prioritize it
-		m.mu.Lock()
-		m.playTarget = item
-		m.mu.Unlock()
-		return nil // It will play when ready
+	defer m.mu.Unlock()
+	if m.current == nil {
+		return QueueItem{}, -1, false
 	}
-	return fmt.Errorf("cannot play item with status %s", item.Status)
+	return *m.current, m.currentIndex(), true
 }
 
-// playNextAvailable attempts to play the next available item in the queue.
-// It should be called when the current playTarget fails.
-// m.mu must be locked.
-func (m *Manager) playNextAvailable(failedItem *QueueItem) {
-	// Find index of failed item
-	idx := -1
-	for i, item := range m.queue {
-		if item == failedItem {
-			idx = i
-			break
-		}
-	}
-
-	if idx == -1 {
-		m.playTarget = nil
-		return
+// GetPlayTarget returns a copy of the item waiting to play once it is
+// downloaded. ok is false when there is none.
+func (m *Manager) GetPlayTarget() (item QueueItem, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.playTarget == nil {
+		return QueueItem{}, false
 	}
+	return *m.playTarget, true
+}
 
-	// Try next items
-	for i := idx + 1; i < len(m.queue); i++ {
-		next := m.queue[i]
-		if next.Status != StatusError {
-			log.Printf("Skipping failed item '%s', targeting '%s'", failedItem.Title, next.Title)
-			m.playTarget = next
-
-			// If ready, play immediately
-			if next.Status == StatusReady || next.Status == StatusPlayed {
-				if err := m.player.Play(next.LocalPath, next.Title); err != nil {
-					log.Printf("Failed to play skipped item %s: %v", next.Title, err)
-					continue
-				}
-				// Success
-				m.playTarget = nil
-				return
-			}
-
-			// If not ready, we have set playTarget. It will play when processed.
-			return
-		}
+// Control Passthroughs
+func (m *Manager) Pause() error                { return m.player.Pause() }
+func (m *Manager) Seek(val float64) error      { return m.player.Seek(val) }
+func (m *Manager) SetVolume(val float64) error { return m.player.SetVolume(val) }
+func (m *Manager) GetStatus() string           { return m.player.GetStatus() }
+
+// GetProperty returns an mpv property, preferring the value pushed by mpv's
+// property-change events over a round-trip on the IPC socket.
+func (m *Manager) GetProperty(prop string) (interface{}, error) {
+	if val, ok := m.player.Observed(prop); ok {
+		return val, nil
 	}
-
-	log.Printf("No further items to play in queue")
-	m.playTarget = nil
+	return m.player.GetProperty(prop)
 }
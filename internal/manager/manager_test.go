@@ -0,0 +1,1011 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/player"
+	"kaboomer/internal/player/mpvtest"
+	"kaboomer/internal/youtube"
+	"kaboomer/internal/ytdlptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	ytdlptest.Main()
+	os.Exit(m.Run())
+}
+
+// newTestManager returns a manager driving a fake mpv. Its downloader has
+// no yt-dlp, so tests queue local files, which need no download.
+func newTestManager(t *testing.T) (*Manager, *mpvtest.Server) {
+	t.Helper()
+	fake := mpvtest.New(t)
+	p, err := player.Attach(fake.SocketPath)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	dir := t.TempDir()
+	dl, err := downloader.New(filepath.Join(dir, "no-yt-dlp"), filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(p, dl, youtube.New("", "")), fake
+}
+
+// newDownloadManager returns a manager driving a fake mpv, downloading with
+// a fake yt-dlp that runs script
+func newDownloadManager(t *testing.T, script ytdlptest.Script) (*Manager, *ytdlptest.Fake) {
+	t.Helper()
+	fake := mpvtest.New(t)
+	p, err := player.Attach(fake.SocketPath)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	ytdlp := ytdlptest.New(t, script)
+	dl, err := downloader.New(ytdlp.Path, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(p, dl, youtube.New("", ytdlp.Path)), ytdlp
+}
+
+// addFiles queues one local file per title and returns their paths
+func addFiles(t *testing.T, m *Manager, titles ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	m.AddLocalDir(dir)
+	paths := make([]string, len(titles))
+	for i, title := range titles {
+		paths[i] = filepath.Join(dir, title+".mp3")
+		if err := os.WriteFile(paths[i], nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		m.Add("file://"+filepath.ToSlash(paths[i]), title, "", "")
+	}
+	return paths
+}
+
+// eventually waits for cond, generously: under -race on a busy machine a
+// fake mpv round trip can take seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitPlaying waits until mpv plays path and the manager agrees on the title
+func waitPlaying(t *testing.T, m *Manager, fake *mpvtest.Server, path, title string) {
+	t.Helper()
+	eventually(t, title+" to play", func() bool {
+		cur, ok := fake.Current()
+		if !ok || cur.Filename != path {
+			return false
+		}
+		item, _, ok := m.GetCurrent()
+		return ok && item.Title == title && item.Status == StatusPlaying
+	})
+}
+
+// statuses returns the status of every queue item
+func statuses(m *Manager) []TrackStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]TrackStatus, len(m.queue))
+	for i, item := range m.queue {
+		out[i] = item.Status
+	}
+	return out
+}
+
+func TestPlayLocalFile(t *testing.T) {
+	m, fake := newTestManager(t)
+	dir := t.TempDir()
+	m.AddLocalDir(dir)
+	path := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Play("file://"+filepath.ToSlash(path), "Song", "", "Artist")
+	waitPlaying(t, m, fake, path, "Song")
+
+	cur, _ := fake.Current()
+	if cur.Title != "Song" {
+		t.Errorf("mpv media title = %q, want Song", cur.Title)
+	}
+}
+
+func TestMissingLocalFile(t *testing.T) {
+	m, _ := newTestManager(t)
+	m.Add("file:///does/not/exist.mp3", "Gone", "", "")
+
+	queue := m.GetQueue()
+	if len(queue) != 1 || queue[0].Status != StatusError {
+		t.Fatalf("queue = %+v, want one errored item", queue)
+	}
+	if err := m.PlayIndex(0); err == nil {
+		t.Error("PlayIndex of a missing file succeeded")
+	}
+}
+
+func TestLocalFileOutsideMusicDir(t *testing.T) {
+	m, _ := newTestManager(t)
+	music := t.TempDir()
+	m.AddLocalDir(music)
+
+	outside := filepath.Join(t.TempDir(), "secret.mp3")
+	if err := os.WriteFile(outside, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(music, "link.mp3")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(music, "album", "song.mp3")
+	os.MkdirAll(filepath.Dir(inside), 0755)
+	if err := os.WriteFile(inside, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{inside, true},
+		{outside, false},
+		{link, false}, // Points outside
+		{filepath.Join(music, "..", filepath.Base(filepath.Dir(outside)), "secret.mp3"), false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		url := "file://" + filepath.ToSlash(tt.path)
+		err := m.CheckURL(url)
+		if tt.allowed != (err == nil) {
+			t.Errorf("CheckURL(%q) = %v, allowed %v", url, err, tt.allowed)
+		}
+	}
+	if err := m.CheckURL("https://www.youtube.com/watch?v=a1"); err != nil {
+		t.Errorf("CheckURL of a YouTube URL = %v", err)
+	}
+
+	// Queued anyway, e.g. from a playlist, it errors without being looked at
+	m.Add("file://"+filepath.ToSlash(outside), "Secret", "", "")
+	queue := m.GetQueue()
+	if queue[0].Status != StatusError || queue[0].Error != ErrLocalFileDenied.Error() {
+		t.Errorf("queued %s %q, want an error", queue[0].Status, queue[0].Error)
+	}
+	if err := m.PlayIndex(0); err == nil {
+		t.Error("PlayIndex of a file outside the music dir succeeded")
+	}
+}
+
+func TestAutoAdvance(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	fake.Finish()
+	waitPlaying(t, m, fake, paths[1], "B")
+	if got := statuses(m); got[0] != StatusPlayed {
+		t.Errorf("finished item status = %s, want played", got[0])
+	}
+
+	fake.Finish()
+	waitPlaying(t, m, fake, paths[2], "C")
+
+	// Nothing after the last item with repeat off
+	fake.Finish()
+	eventually(t, "the last item to be played", func() bool {
+		return statuses(m)[2] == StatusPlayed
+	})
+	if cur, ok := fake.Current(); ok {
+		t.Errorf("mpv still playing %s", cur.Filename)
+	}
+}
+
+func TestSkipsFailedFile(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	fake.Fail("unrecognized file format")
+	waitPlaying(t, m, fake, paths[1], "B")
+
+	queue := m.GetQueue()
+	if queue[0].Status != StatusError || queue[0].Error != "unrecognized file format" {
+		t.Errorf("failed item = %s %q, want error with mpv's reason", queue[0].Status, queue[0].Error)
+	}
+}
+
+func TestPlayModes(t *testing.T) {
+	tests := []struct {
+		mode PlayMode
+		want []string // Titles playing after each Finish, starting from B
+	}{
+		{ModeRepeatOne, []string{"B", "B"}},
+		{ModeRepeatAll, []string{"C", "A", "B"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			m, fake := newTestManager(t)
+			paths := addFiles(t, m, "A", "B", "C")
+			byTitle := map[string]string{"A": paths[0], "B": paths[1], "C": paths[2]}
+
+			if err := m.SetMode(tt.mode, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.PlayIndex(1); err != nil {
+				t.Fatal(err)
+			}
+			waitPlaying(t, m, fake, paths[1], "B")
+
+			for _, title := range tt.want {
+				fake.Finish()
+				waitPlaying(t, m, fake, byTitle[title], title)
+			}
+		})
+	}
+}
+
+func TestNextPrev(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C")
+
+	// Next with nothing played starts at the top
+	m.Next()
+	waitPlaying(t, m, fake, paths[0], "A")
+	m.Next()
+	waitPlaying(t, m, fake, paths[1], "B")
+	m.Prev()
+	waitPlaying(t, m, fake, paths[0], "A")
+}
+
+func TestRemoveCurrent(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	current, _, _ := m.GetCurrent()
+	if err := m.Remove(current.QueueID); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[1], "B")
+	if n := len(m.GetQueue()); n != 1 {
+		t.Errorf("queue has %d items, want 1", n)
+	}
+
+	// Removing the only item stops playback
+	current, _, _ = m.GetCurrent()
+	if err := m.Remove(current.QueueID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "mpv to stop", func() bool {
+		_, ok := fake.Current()
+		return !ok
+	})
+	if _, _, ok := m.GetCurrent(); ok {
+		t.Error("manager still has a current item")
+	}
+
+	if err := m.Remove("nope"); err != ErrItemNotFound {
+		t.Errorf("Remove of an unknown id = %v, want ErrItemNotFound", err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C")
+	if err := m.PlayIndex(1); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[1], "B")
+
+	// ClearQueue keeps what is playing
+	m.ClearQueue()
+	if queue := m.GetQueue(); len(queue) != 1 || queue[0].Title != "B" {
+		t.Errorf("queue after ClearQueue = %+v, want only B", queue)
+	}
+	if _, ok := fake.Current(); !ok {
+		t.Error("ClearQueue stopped playback")
+	}
+
+	// ClearAll doesn't
+	m.ClearAll()
+	if n := len(m.GetQueue()); n != 0 {
+		t.Errorf("queue has %d items after ClearAll, want none", n)
+	}
+	if _, _, ok := m.GetCurrent(); ok {
+		t.Error("manager still has a current item")
+	}
+	eventually(t, "mpv to stop", func() bool {
+		_, ok := fake.Current()
+		return !ok
+	})
+}
+
+func TestControls(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A")
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	if err := m.SetVolume(30); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Seek(95); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "controls to reach mpv", func() bool {
+		vol, _ := fake.Property("volume")
+		pos, _ := fake.Property("time-pos")
+		paused, _ := fake.Property("pause")
+		return vol == 30.0 && pos == 95.0 && paused == true
+	})
+	eventually(t, "observed volume", func() bool {
+		vol, err := m.GetProperty("volume")
+		return err == nil && vol == 30.0
+	})
+}
+
+// mpvFiles returns the files in the fake mpv's playlist
+func mpvFiles(fake *mpvtest.Server) []string {
+	var files []string
+	for _, e := range fake.Playlist() {
+		files = append(files, e.Filename)
+	}
+	return files
+}
+
+// replaces counts the files loaded with loadfile replace
+func replaces(fake *mpvtest.Server) int {
+	var n int
+	for _, cmd := range fake.Commands() {
+		if cmd[0] == "loadfile" && cmd[2] == "replace" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGapless(t *testing.T) {
+	m, fake := newTestManager(t)
+	if err := m.SetTransition(Transition{Gapless: true}); err != nil {
+		t.Fatal(err)
+	}
+	paths := addFiles(t, m, "A", "B", "C")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+	eventually(t, "B to be preloaded", func() bool {
+		return slices.Equal(mpvFiles(fake), []string{paths[0], paths[1]})
+	})
+
+	// Reordering the queue swaps the preload
+	queue := m.GetQueue()
+	if err := m.Move(queue[2].QueueID, 1); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "C to be preloaded", func() bool {
+		return slices.Equal(mpvFiles(fake), []string{paths[0], paths[2]})
+	})
+
+	// mpv moves on by itself, the manager follows without reloading
+	loads := replaces(fake)
+	fake.Finish()
+	waitPlaying(t, m, fake, paths[2], "C")
+	eventually(t, "B to be preloaded", func() bool {
+		return slices.Equal(mpvFiles(fake), []string{paths[2], paths[1]})
+	})
+	fake.Finish()
+	waitPlaying(t, m, fake, paths[1], "B")
+	if n := replaces(fake); n != loads {
+		t.Errorf("loaded %d files with replace during gapless playback", n-loads)
+	}
+	if got, want := statuses(m), []TrackStatus{StatusPlayed, StatusPlayed, StatusPlaying}; !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+
+	// Repeat-all wraps around to A, turning gapless off drops it again
+	if err := m.SetMode(ModeRepeatAll, 0); err != nil {
+		t.Fatal(err)
+	}
+	if files := mpvFiles(fake); !slices.Equal(files, []string{paths[1], paths[0]}) {
+		t.Errorf("mpv playlist = %q, want B then A", files)
+	}
+	if err := m.SetTransition(Transition{}); err != nil {
+		t.Fatal(err)
+	}
+	if files := mpvFiles(fake); !slices.Equal(files, []string{paths[1]}) {
+		t.Errorf("mpv playlist = %q, want only B", files)
+	}
+
+	// Clearing the queue drops the preload with the items
+	if err := m.SetTransition(Transition{Gapless: true}); err != nil {
+		t.Fatal(err)
+	}
+	if files := mpvFiles(fake); !slices.Equal(files, []string{paths[1], paths[0]}) {
+		t.Errorf("mpv playlist = %q, want B then A", files)
+	}
+	m.ClearQueue()
+	if files := mpvFiles(fake); !slices.Equal(files, []string{paths[1]}) {
+		t.Errorf("mpv playlist after clearing the queue = %q, want only B", files)
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+	m, fake := newTestManager(t)
+	if err := m.SetTransition(Transition{Crossfade: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetTransition(Transition{Crossfade: 60}); err == nil {
+		t.Error("SetTransition accepted a 60s crossfade")
+	}
+	paths := addFiles(t, m, "A")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+	if got, _ := fake.FilterCommand("kaboomer-level", "volume"); got != "1.0000*if(isnan(t),1,clip(t/3.000,0,1))" {
+		t.Errorf("volume before the duration is known = %q, want a fade in", got)
+	}
+
+	// The fade out is placed once mpv knows the length
+	fake.SetProperty("duration", 200.0)
+	want := "1.0000*if(isnan(t),1,clip(min(t/3.000,(200.000-t)/3.000),0,1))"
+	eventually(t, "the fade out", func() bool {
+		got, _ := fake.FilterCommand("kaboomer-level", "volume")
+		return got == want
+	})
+}
+
+// newCachedManager returns a test manager whose download cache already holds
+// entries, each with an empty file
+func newCachedManager(t *testing.T, entries ...downloader.Entry) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range entries {
+		entries[i].Path = filepath.Join(dir, entries[i].ID+".m4a")
+		if err := os.WriteFile(entries[i].Path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := mpvtest.New(t)
+	p, err := player.Attach(fake.SocketPath)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	dl, err := downloader.New(filepath.Join(dir, "no-yt-dlp"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(p, dl, youtube.New("", ""))
+}
+
+func TestNormalizationGain(t *testing.T) {
+	// Two cached tracks as loud as each other, one peaking much higher
+	m := newCachedManager(t,
+		downloader.Entry{ID: "quiet", Loudness: &downloader.Loudness{Integrated: -30, TruePeak: -12}},
+		downloader.Entry{ID: "peaky", Loudness: &downloader.Loudness{Integrated: -30, TruePeak: -2}},
+	)
+	// Straight from the cache, a download would keep writing the index after the test
+	if _, err := m.AddCached([]string{"quiet", "peaky"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	gains := func() []float64 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return []float64{m.itemGain(m.queue[0]), m.itemGain(m.queue[1])}
+	}
+
+	// Each track gets as close to -18 LUFS as its own peak allows
+	if err := m.SetNormalization(Normalization{Mode: NormalizeTrack, TargetLUFS: -18}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gains(), []float64{11, 1}; !slices.Equal(got, want) {
+		t.Errorf("track gains = %v, want %v", got, want)
+	}
+	// One gain for the queue, held back by the highest peak in it
+	if err := m.SetNormalization(Normalization{Mode: NormalizeAlbum, TargetLUFS: -18}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gains(), []float64{1, 1}; !slices.Equal(got, want) {
+		t.Errorf("album gains = %v, want %v", got, want)
+	}
+}
+
+func TestDownloadProgressKind(t *testing.T) {
+	m, _ := newDownloadManager(t, ytdlptest.Script{Download: &ytdlptest.Download{Size: 4000, Updates: 4}})
+	changes, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	m.Add("https://www.youtube.com/watch?v=a1", "A", "a1", "")
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case kind := <-changes:
+			if kind == ChangeProgress {
+				// Let the download finish before its directory is removed
+				eventually(t, "A to download", func() bool {
+					return statuses(m)[0] == StatusReady
+				})
+				return
+			}
+		case <-timeout:
+			t.Fatal("no download progress change")
+		}
+	}
+}
+
+func TestSubscribeCoalesces(t *testing.T) {
+	m, _ := newTestManager(t)
+	changes, unsubscribe := m.Subscribe()
+
+	// A burst of status changes must not crowd out the queue change after it
+	for range 50 {
+		m.notify(ChangeStatus)
+	}
+	m.notify(ChangeQueue)
+
+	got := make(map[ChangeKind]bool)
+	for !got[ChangeQueue] {
+		select {
+		case kind := <-changes:
+			got[kind] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %v, never the queue change", got)
+		}
+	}
+	if !got[ChangeStatus] {
+		t.Error("never received the status change")
+	}
+
+	unsubscribe()
+	eventually(t, "the channel to close", func() bool {
+		select {
+		case _, ok := <-changes:
+			return !ok
+		default:
+			return false
+		}
+	})
+}
+
+func TestAddCached(t *testing.T) {
+	m := newCachedManager(t,
+		downloader.Entry{ID: "known", Title: "Known", SourceURL: "https://youtu.be/known"},
+		downloader.Entry{ID: "scanned"}, // Rebuilt from a directory scan
+	)
+
+	queueIDs, err := m.AddCached([]string{"known", "scanned"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := m.GetQueue()
+	if len(queue) != 2 || len(queueIDs) != 2 {
+		t.Fatalf("queued %d items, returned %d queue ids; want 2", len(queue), len(queueIDs))
+	}
+	for i, item := range queue {
+		if item.QueueID != queueIDs[i] {
+			t.Errorf("queue id %d = %q, want %q", i, queueIDs[i], item.QueueID)
+		}
+		if item.Status != StatusReady {
+			t.Errorf("%s is %s, want ready", item.ID, item.Status)
+		}
+	}
+	if queue[0].URL != "https://youtu.be/known" {
+		t.Errorf("known URL = %q, want its source URL", queue[0].URL)
+	}
+	if want := "https://www.youtube.com/watch?v=scanned"; queue[1].URL != want {
+		t.Errorf("scanned URL = %q, want %q", queue[1].URL, want)
+	}
+
+	if _, err := m.AddCached([]string{"known", "gone"}, false); !errors.Is(err, ErrNotCached) {
+		t.Errorf("AddCached of a missing track = %v, want ErrNotCached", err)
+	}
+	if n := len(m.GetQueue()); n != 2 {
+		t.Errorf("queue holds %d items after a failed add, want 2", n)
+	}
+}
+
+func TestRestoreCorruptState(t *testing.T) {
+	m, _ := newTestManager(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"queue": [{"id": "a1"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RestoreState(path, false); err == nil {
+		t.Fatal("RestoreState accepted a corrupt file")
+	}
+	// The corrupt file is kept for a look, the autosave writes a fresh one
+	if data, err := os.ReadFile(path + ".corrupt"); err != nil || string(data) != `{"queue": [{"id": "a1"` {
+		t.Errorf("corrupt state = %q, %v; want it moved aside as it was", data, err)
+	}
+	addFiles(t, m, "A")
+	if err := m.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	m2, _ := newTestManager(t)
+	if err := m2.RestoreState(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if queue := m2.GetQueue(); len(queue) != 1 || queue[0].Title != "A" {
+		t.Errorf("restored queue = %+v, want A", queue)
+	}
+}
+
+func TestSaveStateSkipsUnchanged(t *testing.T) {
+	m, _ := newTestManager(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := m.RestoreState(path, false); err != nil {
+		t.Fatal(err)
+	}
+	addFiles(t, m, "A")
+
+	save := func() string {
+		t.Helper()
+		if err := m.SaveState(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	first := save()
+	if again := save(); again != first {
+		t.Errorf("state rewritten with nothing changed:\n%s\nthen\n%s", first, again)
+	}
+	addFiles(t, m, "B")
+	if after := save(); after == first {
+		t.Error("state not saved after adding B")
+	}
+}
+
+func TestPruneKeepsQueuedTracks(t *testing.T) {
+	now := time.Now()
+	m := newCachedManager(t,
+		downloader.Entry{ID: "queued", DownloadedAt: now.Add(-time.Hour)},
+		downloader.Entry{ID: "old", DownloadedAt: now.Add(-time.Minute)},
+	)
+	m.downloader.SetLimits(0, 1)
+
+	// Waiting for the scheduler, so not pointed at its file yet
+	m.mu.Lock()
+	m.queue = append(m.queue, &QueueItem{QueueID: "q1", ID: "queued", Status: StatusPending})
+	m.mu.Unlock()
+
+	result, err := m.PruneCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 1 {
+		t.Errorf("evicted %d files, want 1", result.Files)
+	}
+	if _, ok := m.downloader.Lookup("queued"); !ok {
+		t.Error("evicted the queued track")
+	}
+	if _, ok := m.downloader.Lookup("old"); ok {
+		t.Error("kept the track nobody queued")
+	}
+}
+
+func TestInsertNextAfterPlayTarget(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C")
+	if err := m.SetMode(ModeShuffle, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	// Asked to play, still downloading
+	m.mu.Lock()
+	target := &QueueItem{QueueID: "target", ID: "t1", Title: "T", Status: StatusPending}
+	m.queue = append(m.queue, target)
+	m.orderAdd(target, false)
+	m.playTarget = target
+	m.mu.Unlock()
+
+	dir := t.TempDir()
+	m.AddLocalDir(dir)
+	path := filepath.Join(dir, "D.mp3")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	queueID := m.InsertNext("file://"+filepath.ToSlash(path), "D", "", "")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, idx := m.itemByQueueID(queueID)
+	if item == nil || item.Title != "D" {
+		t.Fatalf("InsertNext returned %q, not D's queue id", queueID)
+	}
+	if want := m.indexOf(target) + 1; idx != want {
+		t.Errorf("D is at queue index %d, want %d", idx, want)
+	}
+	if got, want := orderIndex(m.shuffleOrder, item), orderIndex(m.shuffleOrder, target)+1; got != want {
+		t.Errorf("D is at shuffle position %d, want %d", got, want)
+	}
+}
+
+func TestEndWhilePlayTargetDownloads(t *testing.T) {
+	m, fake := newTestManager(t)
+	jobs := newTestJobs()
+	m.mu.Lock()
+	m.downloads = newScheduler(1, jobs.run) // Downloads never finish
+	go m.downloads.loop()
+	m.mu.Unlock()
+	if err := m.SetTransition(Transition{Gapless: true}); err != nil {
+		t.Fatal(err)
+	}
+	paths := addFiles(t, m, "A", "B")
+
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+	eventually(t, "B to be preloaded", func() bool {
+		return slices.Equal(mpvFiles(fake), []string{paths[0], paths[1]})
+	})
+
+	m.Play("https://example.com/watch?v=target", "T", "target", "")
+	if title := jobs.next(t); title != "T" {
+		t.Fatalf("downloading %s, want T", title)
+	}
+	if files := mpvFiles(fake); !slices.Equal(files, []string{paths[0]}) {
+		t.Errorf("mpv playlist = %q, want B's preload dropped", files)
+	}
+
+	// A ending waits for T rather than moving on to B
+	fake.Finish()
+	eventually(t, "A to be played", func() bool {
+		return statuses(m)[0] == StatusPlayed
+	})
+	time.Sleep(50 * time.Millisecond)
+	if target, ok := m.GetPlayTarget(); !ok || target.Title != "T" {
+		t.Errorf("play target = %q, want T", target.Title)
+	}
+	if got := statuses(m)[1]; got != StatusReady {
+		t.Errorf("B is %s, want ready", got)
+	}
+	if cur, ok := fake.Current(); ok {
+		t.Errorf("mpv playing %s while T downloads", cur.Filename)
+	}
+}
+
+// shuffleTitles returns the titles in the shuffled play order
+func shuffleTitles(m *Manager) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, len(m.shuffleOrder))
+	for i, item := range m.shuffleOrder {
+		out[i] = item.Title
+	}
+	return out
+}
+
+// checkPermutation fails unless the shuffled order holds every queue item once
+func checkPermutation(t *testing.T, m *Manager, when string) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[*QueueItem]int)
+	for _, item := range m.shuffleOrder {
+		seen[item]++
+	}
+	ok := len(m.shuffleOrder) == len(m.queue)
+	for _, item := range m.queue {
+		ok = ok && seen[item] == 1
+	}
+	if !ok {
+		t.Errorf("%s: shuffled order of %d items isn't a permutation of the %d queued", when, len(m.shuffleOrder), len(m.queue))
+	}
+}
+
+func TestShuffleSeed(t *testing.T) {
+	titles := []string{"A", "B", "C", "D", "E", "F"}
+	orders := make([][]string, 3)
+	for i, seed := range []uint64{7, 7, 8} {
+		m, _ := newTestManager(t)
+		addFiles(t, m, titles...)
+		if err := m.SetMode(ModeShuffle, seed); err != nil {
+			t.Fatal(err)
+		}
+		if mode, got := m.GetMode(); mode != ModeShuffle || got != seed {
+			t.Errorf("GetMode() = %s, %d; want shuffle, %d", mode, got, seed)
+		}
+		orders[i] = shuffleTitles(m)
+	}
+
+	if !slices.Equal(orders[0], orders[1]) {
+		t.Errorf("seed 7 gave %v, then %v", orders[0], orders[1])
+	}
+	if slices.Equal(orders[0], orders[2]) {
+		t.Errorf("seeds 7 and 8 both gave %v", orders[0])
+	}
+	if slices.Equal(orders[0], titles) {
+		t.Errorf("seed 7 left the queue order as it was")
+	}
+
+	// 0 asks for a random seed
+	m, _ := newTestManager(t)
+	if err := m.SetMode(ModeShuffle, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, seed := m.GetMode(); seed == 0 {
+		t.Error("SetMode(shuffle, 0) kept seed 0")
+	}
+}
+
+func TestShufflePrev(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C", "D")
+	byTitle := map[string]string{"A": paths[0], "B": paths[1], "C": paths[2], "D": paths[3]}
+	if err := m.SetMode(ModeShuffle, 7); err != nil {
+		t.Fatal(err)
+	}
+	order := shuffleTitles(m)
+
+	// Next walks the shuffled order, Prev walks back through what played
+	for _, i := range []int{0, 1, 2} {
+		m.Next()
+		waitPlaying(t, m, fake, byTitle[order[i]], order[i])
+	}
+	for _, i := range []int{1, 0} {
+		m.Prev()
+		waitPlaying(t, m, fake, byTitle[order[i]], order[i])
+	}
+
+	// The order stays put, Next goes forward through it again
+	m.Next()
+	waitPlaying(t, m, fake, byTitle[order[1]], order[1])
+	if got := shuffleTitles(m); !slices.Equal(got, order) {
+		t.Errorf("shuffled order = %v after Prev, want %v", got, order)
+	}
+}
+
+func TestShuffleQueueEdits(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B", "C")
+	if err := m.SetMode(ModeShuffle, 7); err != nil {
+		t.Fatal(err)
+	}
+	checkPermutation(t, m, "shuffle")
+	first := shuffleTitles(m)[0]
+	m.Next()
+	waitPlaying(t, m, fake, paths[slices.Index([]string{"A", "B", "C"}, first)], first)
+
+	addFiles(t, m, "D", "E")
+	checkPermutation(t, m, "add")
+
+	queue := m.GetQueue()
+	if err := m.Remove(queue[1].QueueID); err != nil {
+		t.Fatal(err)
+	}
+	checkPermutation(t, m, "remove")
+
+	current, _, _ := m.GetCurrent()
+	if err := m.Remove(current.QueueID); err != nil {
+		t.Fatal(err)
+	}
+	checkPermutation(t, m, "remove the current item")
+
+	dir := t.TempDir()
+	m.AddLocalDir(dir)
+	path := filepath.Join(dir, "F.mp3")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.InsertNext("file://"+filepath.ToSlash(path), "F", "", "")
+	checkPermutation(t, m, "insert next")
+	m.Next()
+	eventually(t, "F to play", func() bool {
+		item, _, ok := m.GetCurrent()
+		return ok && item.Title == "F"
+	})
+
+	if err := m.Move(m.GetQueue()[0].QueueID, 3); err != nil {
+		t.Fatal(err)
+	}
+	checkPermutation(t, m, "move")
+	m.ClearQueue()
+	checkPermutation(t, m, "clear")
+}
+
+// restart crashes the fake mpv and, once the player has reconnected to the
+// new one, tells the manager as the supervisor would
+func restart(t *testing.T, m *Manager, fake *mpvtest.Server) {
+	t.Helper()
+	fake.Restart()
+	eventually(t, "the player to reconnect", func() bool {
+		_, err := m.player.GetProperty("idle-active")
+		return err == nil
+	})
+	m.handleRestart()
+}
+
+func TestRestartResumes(t *testing.T) {
+	m, fake := newTestManager(t)
+	paths := addFiles(t, m, "A", "B")
+	if err := m.PlayIndex(0); err != nil {
+		t.Fatal(err)
+	}
+	waitPlaying(t, m, fake, paths[0], "A")
+
+	// As trackPlayback last sampled it
+	m.mu.Lock()
+	m.lastPlayback = playbackSnapshot{item: m.current, position: 42, paused: true, volume: 70, hasVolume: true}
+	m.mu.Unlock()
+
+	restart(t, m, fake)
+	waitPlaying(t, m, fake, paths[0], "A")
+	eventually(t, "playback state restored", func() bool {
+		pos, _ := fake.Property("time-pos")
+		paused, _ := fake.Property("pause")
+		vol, _ := fake.Property("volume")
+		return pos == 42.0 && paused == true && vol == 70.0
+	})
+
+	// Events of the new mpv belong to the reloaded entry
+	fake.Finish()
+	waitPlaying(t, m, fake, paths[1], "B")
+
+	// A sample of another track doesn't carry over its position
+	restart(t, m, fake)
+	waitPlaying(t, m, fake, paths[1], "B")
+	if pos, _ := fake.Property("time-pos"); pos != 0.0 {
+		t.Errorf("B reloaded at %v, want the start", pos)
+	}
+	if paused, _ := fake.Property("pause"); paused != false {
+		t.Error("B reloaded paused")
+	}
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand/v2"
+)
+
+// PlayMode decides what plays after the current item
+type PlayMode string
+
+const (
+	ModeOff       PlayMode = "off"
+	ModeRepeatAll PlayMode = "repeat-all"
+	ModeRepeatOne PlayMode = "repeat-one"
+	ModeShuffle   PlayMode = "shuffle"
+)
+
+// SetMode switches the play mode. For shuffle, seed picks the permutation so the
+// same seed over the same queue gives the same order; 0 picks a random seed.
+// The displayed queue is never reordered, only the order things play in.
+func (m *Manager) SetMode(mode PlayMode, seed uint64) error {
+	switch mode {
+	case ModeOff, ModeRepeatAll, ModeRepeatOne, ModeShuffle:
+	default:
+		return fmt.Errorf("unknown play mode %q", mode)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mode = mode
+	if mode == ModeShuffle {
+		if seed == 0 {
+			seed = randomSeed()
+		}
+		m.shuffle(seed)
+	} else {
+		m.shuffleSeed = 0
+		m.shuffleOrder = nil
+		m.shuffleRand = nil
+	}
+	m.syncPreload()
+
+	m.notify(ChangeStatus)
+	return nil
+}
+
+// GetMode returns the play mode and, in shuffle mode, the seed in use
+func (m *Manager) GetMode() (PlayMode, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mode, m.shuffleSeed
+}
+
+// shuffle builds the shuffled play order: items already heard keep their
+// order so Prev walks back through them, then the current item, then a
+// seeded permutation of everything else. m.mu must be locked.
+func (m *Manager) shuffle(seed uint64) {
+	m.shuffleSeed = seed
+	m.shuffleRand = mrand.New(mrand.NewPCG(seed, seed))
+
+	var heard, rest []*QueueItem
+	for _, item := range m.queue {
+		switch {
+		case item == m.current:
+		case item.Status == StatusPlayed:
+			heard = append(heard, item)
+		default:
+			rest = append(rest, item)
+		}
+	}
+	m.shuffleRand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+
+	order := heard
+	if m.current != nil {
+		order = append(order, m.current)
+	}
+	m.shuffleOrder = append(order, rest...)
+}
+
+// playOrder is the order items play in: the queue itself, or the shuffled
+// order in shuffle mode. m.mu must be locked.
+func (m *Manager) playOrder() []*QueueItem {
+	if m.mode == ModeShuffle {
+		return m.shuffleOrder
+	}
+	return m.queue
+}
+
+// orderAdd registers a new queue item with the shuffled order. Items played
+// next go right after the current one, others at a random upcoming spot.
+// m.mu must be locked.
+func (m *Manager) orderAdd(item *QueueItem, next bool) {
+	if m.mode != ModeShuffle {
+		return
+	}
+	pos := orderIndex(m.shuffleOrder, m.current) + 1
+	if !next {
+		pos += m.shuffleRand.IntN(len(m.shuffleOrder) - pos + 1)
+	}
+	m.orderInsert(pos, item)
+}
+
+// orderAddAfter registers a new queue item with the shuffled order, right
+// after anchor, or first if anchor is nil. m.mu must be locked.
+func (m *Manager) orderAddAfter(item, anchor *QueueItem) {
+	if m.mode != ModeShuffle {
+		return
+	}
+	m.orderInsert(orderIndex(m.shuffleOrder, anchor)+1, item)
+}
+
+// orderInsert puts item at pos in the shuffled order. m.mu must be locked.
+func (m *Manager) orderInsert(pos int, item *QueueItem) {
+	m.shuffleOrder = append(m.shuffleOrder, nil)
+	copy(m.shuffleOrder[pos+1:], m.shuffleOrder[pos:])
+	m.shuffleOrder[pos] = item
+}
+
+// orderRemove drops an item from the shuffled order. m.mu must be locked.
+func (m *Manager) orderRemove(item *QueueItem) {
+	if idx := orderIndex(m.shuffleOrder, item); idx != -1 {
+		m.shuffleOrder = append(m.shuffleOrder[:idx], m.shuffleOrder[idx+1:]...)
+	}
+}
+
+func orderIndex(order []*QueueItem, item *QueueItem) int {
+	for i, queued := range order {
+		if queued == item {
+			return i
+		}
+	}
+	return -1
+}
+
+func randomSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	// Keep it within float64's exact integer range so it round-trips through JSON
+	return binary.LittleEndian.Uint64(b[:]) >> 11
+}
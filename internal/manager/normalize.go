@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// NormalizeMode decides how the playback gain of a track is picked
+type NormalizeMode string
+
+const (
+	NormalizeOff   NormalizeMode = "off"
+	NormalizeTrack NormalizeMode = "track" // Every track at the target loudness
+	NormalizeAlbum NormalizeMode = "album" // One gain for the whole queue, keeps the differences between tracks
+)
+
+// peakCeiling is the highest true peak gain may push a track to, in dBTP
+const peakCeiling = -1.0
+
+// Normalization is the loudness normalisation setting
+type Normalization struct {
+	Mode       NormalizeMode `json:"mode"`
+	TargetLUFS float64       `json:"target_lufs"`
+}
+
+// DefaultNormalization brings tracks to -18 LUFS, the ReplayGain 2 reference
+var DefaultNormalization = Normalization{Mode: NormalizeTrack, TargetLUFS: -18}
+
+// SetNormalization changes the normalisation setting and applies it to the
+// current track right away
+func (m *Manager) SetNormalization(n Normalization) error {
+	switch n.Mode {
+	case NormalizeOff, NormalizeTrack, NormalizeAlbum:
+	default:
+		return fmt.Errorf("unknown normalization mode %q", n.Mode)
+	}
+	if n.TargetLUFS < -70 || n.TargetLUFS > 0 {
+		return fmt.Errorf("target loudness %.1f LUFS out of range", n.TargetLUFS)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.normalization = n
+	if m.current != nil && m.current.Status == StatusPlaying {
+		m.applyGain(m.current)
+	}
+	m.notify(ChangeStatus)
+	return nil
+}
+
+// GetNormalization returns the normalisation setting
+func (m *Manager) GetNormalization() Normalization {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.normalization
+}
+
+// StartLoudnessAnalysis measures cached tracks with ffmpeg in the background.
+// Each track measured while it plays gets its gain applied at once.
+func (m *Manager) StartLoudnessAnalysis(ffmpegPath string) error {
+	return m.downloader.StartAnalysis(ffmpegPath, m.loudnessMeasured)
+}
+
+func (m *Manager) loudnessMeasured(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.normalization.Mode == NormalizeAlbum {
+		// The queue's loudness changed, and with it everyone's gain
+		if m.current != nil && m.current.Status == StatusPlaying {
+			m.applyGain(m.current)
+		}
+		return
+	}
+	if m.current != nil && m.current.Status == StatusPlaying && m.current.ID == id {
+		m.applyGain(m.current)
+	}
+}
+
+// applyGain sets the mpv gain for item. m.mu must be locked.
+func (m *Manager) applyGain(item *QueueItem) {
+	if err := m.player.SetGain(m.itemGain(item)); err != nil {
+		log.Printf("Failed to set gain for %s: %v", item.Title, err)
+	}
+}
+
+// itemGain works out the gain in dB for item. Tracks not measured yet play
+// as they are. m.mu must be locked.
+func (m *Manager) itemGain(item *QueueItem) float64 {
+	if m.normalization.Mode == NormalizeOff {
+		return 0
+	}
+	entry, ok := m.downloader.Lookup(item.ID)
+	if !ok || entry.Loudness == nil {
+		return 0
+	}
+
+	loudness, peak := entry.Loudness.Integrated, entry.Loudness.TruePeak
+	if m.normalization.Mode == NormalizeAlbum {
+		if l, p, ok := m.queueLoudness(); ok {
+			loudness, peak = l, p
+		}
+	}
+	gain := m.normalization.TargetLUFS - loudness
+
+	// Never push the peaks into clipping
+	if limit := peakCeiling - peak; gain > limit {
+		gain = limit
+	}
+	return gain
+}
+
+// queueLoudness is the loudness of the measured queue items played back to
+// back: the energy average of their integrated loudness, and the highest true
+// peak among them, so one gain suits them all. ok is false if none are
+// measured. m.mu must be locked.
+func (m *Manager) queueLoudness() (loudness, peak float64, ok bool) {
+	var energy float64
+	var n int
+	seen := make(map[string]bool)
+	for _, item := range m.queue {
+		if seen[item.ID] {
+			continue
+		}
+		seen[item.ID] = true
+		entry, found := m.downloader.Lookup(item.ID)
+		if !found || entry.Loudness == nil {
+			continue
+		}
+		energy += math.Pow(10, entry.Loudness.Integrated/10)
+		if n == 0 || entry.Loudness.TruePeak > peak {
+			peak = entry.Loudness.TruePeak
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+	return 10 * math.Log10(energy/float64(n)), peak, true
+}
@@ -0,0 +1,245 @@
+package manager
+
+import (
+	"fmt"
+	"kaboomer/internal/player"
+	"log"
+)
+
+// The manager owns the play order. mpv only ever has the current file loaded
+// (loadfile replace); when it reports end-file with reason eof the manager
+// picks what comes next. Next/Prev, skipping broken items and auto-advance
+// all go through advance. In gapless mode the next item is also preloaded
+// (see transition.go), and if it is still what advance would pick, mpv is
+// left to move on to it by itself.
+
+func (m *Manager) handleStartFile(ev player.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item := m.itemByEntry(ev.PlaylistEntryID); item != nil {
+		if item != m.current {
+			// mpv moved on to the preloaded item
+			m.applyGain(item)
+			m.applyFade(0)
+		}
+		m.setCurrent(item)
+	}
+}
+
+func (m *Manager) handleEndFile(ev player.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item := m.itemByEntry(ev.PlaylistEntryID)
+	if item == nil || item != m.current || item.Status != StatusPlaying {
+		// Replaced by a file we loaded ourselves
+		return
+	}
+
+	switch ev.Reason {
+	case player.EndReasonEOF:
+		item.Status = StatusPlayed
+		m.notify(ChangeQueue)
+		if next := m.preloaded; next != nil && next.entryID != 0 && next == m.autoNext() {
+			// mpv is already starting it, start-file makes it current
+			m.preloaded = nil
+			return
+		}
+		m.forgetPreload()
+		m.advance(item, 1, true)
+	case player.EndReasonError:
+		log.Printf("mpv failed to play %s: %s", item.Title, ev.FileError)
+		item.Status = StatusError
+		item.Error = ev.FileError
+		m.notify(ChangeQueue)
+		m.advance(item, 1, false)
+	default:
+		item.Status = StatusPlayed
+		m.notify(ChangeQueue)
+	}
+}
+
+// itemByEntry finds the queue item loaded into mpv under the given entry id.
+// m.mu must be locked.
+func (m *Manager) itemByEntry(entryID int) *QueueItem {
+	if entryID == 0 {
+		return nil
+	}
+	for _, item := range m.queue {
+		if item.entryID == entryID {
+			return item
+		}
+	}
+	return nil
+}
+
+// currentIndex returns the queue position of the current item, or -1.
+// m.mu must be locked.
+func (m *Manager) currentIndex() int {
+	if m.current == nil {
+		return -1
+	}
+	return m.indexOf(m.current)
+}
+
+// setCurrent marks item as the one playing. m.mu must be locked.
+func (m *Manager) setCurrent(item *QueueItem) {
+	if m.current != nil && m.current != item && m.current.Status == StatusPlaying {
+		m.current.Status = StatusPlayed
+	}
+	if m.current != item {
+		m.downloader.MarkPlayed(item.ID)
+	}
+	m.current = item
+	item.Status = StatusPlaying
+	if m.playTarget == item {
+		m.playTarget = nil
+	}
+	// Get the following track on disk before it is needed
+	if next := m.upNext(); next != nil && next.Status == StatusPending {
+		m.downloads.push(next, priorityNextUp)
+	}
+	m.syncPreload()
+	m.notify(ChangeQueue)
+}
+
+// playItem loads a ready item into mpv, replacing whatever was playing.
+// m.mu must be locked.
+func (m *Manager) playItem(item *QueueItem) error {
+	m.forgetPreload() // Replaced along with everything else in mpv
+	m.applyGain(item)
+	m.applyFade(0)
+	id, err := m.player.Load(item.LocalPath, item.Title, 0)
+	if err != nil {
+		return err
+	}
+	item.entryID = id
+	m.setCurrent(item)
+	return nil
+}
+
+// startItem plays a queue item now, or makes it the play target if it is
+// still downloading. m.mu must be locked.
+func (m *Manager) startItem(item *QueueItem) error {
+	switch {
+	case item.playable():
+		return m.playItem(item)
+	case item.Status == StatusPending || item.Status == StatusDownloading:
+		m.playTarget = item
+		m.downloads.push(item, priorityPlayNow)
+		m.syncPreload() // The target plays next, not what was preloaded
+		m.notify(ChangeStatus)
+		return nil
+	}
+	return fmt.Errorf("cannot play item with status %s", item.Status)
+}
+
+// advance starts the first usable item dir steps (+1 forward, -1 back) from
+// `from` in the play order, skipping errored items and wrapping around in
+// repeat-all mode. auto means `from` just finished on its own, in which case
+// repeat-one plays it again. While the user's play target downloads it is
+// what comes next, from anywhere but itself. It reports whether anything was
+// started or targeted. m.mu must be locked.
+func (m *Manager) advance(from *QueueItem, dir int, auto bool) bool {
+	if m.playTarget != nil && m.playTarget != from {
+		return true // Starts once it is on disk
+	}
+	if auto && m.mode == ModeRepeatOne && from.playable() {
+		if err := m.playItem(from); err == nil {
+			return true
+		}
+	}
+
+	order := m.playOrder()
+	pos := orderIndex(order, from)
+	if pos == -1 {
+		return false
+	}
+
+	n := len(order)
+	for step := 1; step <= n; step++ {
+		i := pos + dir*step
+		if i < 0 || i >= n {
+			if m.mode != ModeRepeatAll {
+				break
+			}
+			i = (i%n + n) % n
+		}
+
+		next := order[i]
+		if next.Status == StatusError {
+			continue
+		}
+		if err := m.startItem(next); err != nil {
+			log.Printf("Failed to play %s: %v", next.Title, err)
+			continue
+		}
+		return true
+	}
+
+	log.Printf("No further items to play in queue")
+	return false
+}
+
+// Next plays the item after the current one in the play order
+func (m *Manager) Next() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.step(1)
+	return nil
+}
+
+// Prev plays the item before the current one in the play order.
+// In shuffle mode this walks back through what was played.
+func (m *Manager) Prev() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.step(-1)
+	return nil
+}
+
+// step moves dir items from whatever is playing or about to play.
+// m.mu must be locked.
+func (m *Manager) step(dir int) {
+	from := m.current
+	if m.playTarget != nil {
+		from = m.playTarget
+	}
+	if from != nil {
+		m.advance(from, dir, false)
+		return
+	}
+
+	// Nothing played yet, start from the top of the play order
+	if order := m.playOrder(); len(order) > 0 && dir > 0 {
+		if err := m.startItem(order[0]); err != nil {
+			m.advance(order[0], 1, false)
+		}
+	}
+}
+
+// PlayIndex plays an item from the queue.
+// Items that are still downloading become the play target and start when ready.
+func (m *Manager) PlayIndex(index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.playIndex(index)
+}
+
+// playIndex is PlayIndex without locking. m.mu must be locked.
+func (m *Manager) playIndex(index int) error {
+	if index < 0 || index >= len(m.queue) {
+		return fmt.Errorf("index out of bounds")
+	}
+	item := m.queue[index]
+
+	if err := m.startItem(item); err != nil {
+		if !item.playable() {
+			return err
+		}
+		log.Printf("Failed to play %s: %v", item.Title, err)
+		m.advance(item, 1, false)
+	}
+	return nil
+}
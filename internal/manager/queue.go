@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrItemNotFound is returned when a queue id doesn't match any queue item
+var ErrItemNotFound = errors.New("queue item not found")
+
+// itemByQueueID finds a queue item and its position. m.mu must be locked.
+func (m *Manager) itemByQueueID(queueID string) (*QueueItem, int) {
+	for i, item := range m.queue {
+		if item.QueueID == queueID {
+			return item, i
+		}
+	}
+	return nil, -1
+}
+
+// Remove takes an item out of the queue, cancelling its download if one is
+// waiting or running. If the item was playing, playback moves on to the next one.
+func (m *Manager) Remove(queueID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, idx := m.itemByQueueID(queueID)
+	if item == nil {
+		return ErrItemNotFound
+	}
+
+	if m.current == item {
+		m.advance(item, 1, false)
+		if m.current == item {
+			// Nothing ready to take over
+			if err := m.player.StopPlayback(); err != nil {
+				log.Printf("Failed to stop %s: %v", item.Title, err)
+			}
+			m.current = nil
+		}
+	}
+
+	m.downloads.cancel(item)
+	m.queue = append(m.queue[:idx], m.queue[idx+1:]...)
+	m.orderRemove(item)
+	if m.playTarget == item {
+		m.playTarget = nil
+	}
+	m.syncPreload()
+
+	m.notify(ChangeQueue)
+	m.notify(ChangeStatus)
+	return nil
+}
+
+// Move puts an item at a new queue index, clamped to the queue bounds
+func (m *Manager) Move(queueID string, index int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, idx := m.itemByQueueID(queueID)
+	if item == nil {
+		return ErrItemNotFound
+	}
+
+	m.queue = append(m.queue[:idx], m.queue[idx+1:]...)
+	if index < 0 {
+		index = 0
+	}
+	if index > len(m.queue) {
+		index = len(m.queue)
+	}
+	m.insertAt(index, item)
+	m.syncPreload()
+
+	m.notify(ChangeQueue)
+	return nil
+}
+
+// InsertNext queues a track to play right after the current item
+// (or the item waiting to play) and returns its queue id
+func (m *Manager) InsertNext(url, title, id, artist string) string {
+	m.mu.Lock()
+	item := m.newItem(url, title, id, artist)
+
+	anchor := m.current
+	if m.playTarget != nil {
+		anchor = m.playTarget
+	}
+	m.insertAt(m.indexOf(anchor)+1, item)
+	m.orderAddAfter(item, anchor)
+	m.enqueue(item)
+	m.syncPreload()
+	queueID := item.QueueID
+	m.mu.Unlock()
+	m.notify(ChangeQueue)
+	return queueID
+}
+
+// insertAt inserts item at index. m.mu must be locked.
+func (m *Manager) insertAt(index int, item *QueueItem) {
+	m.queue = append(m.queue, nil)
+	copy(m.queue[index+1:], m.queue[index:])
+	m.queue[index] = item
+}
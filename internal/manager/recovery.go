@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"kaboomer/internal/player"
+	"log"
+	"time"
+)
+
+// playbackPollInterval is how often the playback position is sampled, which
+// bounds how much of a track is replayed after mpv crashes
+const playbackPollInterval = 2 * time.Second
+
+// playbackSnapshot is the last known playback state, restored when mpv is
+// restarted after a crash
+type playbackSnapshot struct {
+	item      *QueueItem
+	position  float64
+	paused    bool
+	volume    float64
+	hasVolume bool
+}
+
+// trackPlayback samples the position, pause and volume while something plays,
+// and keeps the gapless preload up to date
+func (m *Manager) trackPlayback() {
+	ticker := time.NewTicker(playbackPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if idle, ok := m.player.Observed("idle-active"); ok && idle == true {
+			continue
+		}
+		pos, err := m.player.GetProperty("time-pos")
+		if err != nil {
+			continue
+		}
+		posFloat, ok := pos.(float64)
+		if !ok {
+			continue
+		}
+
+		snap := playbackSnapshot{position: posFloat}
+		if paused, ok := m.player.Observed("pause"); ok {
+			snap.paused = paused == true
+		}
+		if vol, ok := m.player.Observed("volume"); ok {
+			snap.volume, snap.hasVolume = vol.(float64)
+		}
+
+		m.mu.Lock()
+		snap.item = m.current
+		m.lastPlayback = snap
+		m.syncPreload() // Catches downloads finishing and queue edits
+		m.mu.Unlock()
+	}
+}
+
+// handleRestart puts the new mpv back where the crashed one was: same track,
+// last sampled position, pause state and volume
+func (m *Manager) handleRestart() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Entry ids start over in the new mpv, the old ones must not match its events
+	for _, item := range m.queue {
+		item.entryID = 0
+	}
+	m.preloaded = nil
+
+	snap := m.lastPlayback
+	if snap.hasVolume {
+		if err := m.player.SetVolume(snap.volume); err != nil {
+			log.Printf("Failed to restore volume after mpv restart: %v", err)
+		}
+	}
+
+	item := m.current
+	if item == nil || item.Status != StatusPlaying {
+		return
+	}
+	position := 0.0
+	if snap.item == item {
+		position = snap.position
+	}
+
+	log.Printf("Reloading %s at %.0fs after mpv restart", item.Title, position)
+	if err := m.resumeItem(item, position); err != nil {
+		log.Printf("Failed to reload %s: %v", item.Title, err)
+		m.advance(item, 1, false)
+		return
+	}
+	if snap.item == item && snap.paused {
+		if err := m.player.SetPaused(true); err != nil {
+			log.Printf("Failed to restore pause after mpv restart: %v", err)
+		}
+	}
+}
+
+// PlayerHealth reports the mpv process state and its crash and restart counts
+func (m *Manager) PlayerHealth() player.Health {
+	return m.player.Health()
+}
@@ -0,0 +1,134 @@
+package manager
+
+import (
+	"errors"
+	"kaboomer/internal/downloader"
+	"log"
+	"time"
+)
+
+// ErrNotFailed is returned when retrying a queue item that has no error
+var ErrNotFailed = errors.New("queue item has not failed")
+
+// RetryPolicy decides how often and how soon failed downloads are tried again
+type RetryPolicy struct {
+	MaxAttempts int           // Downloads per item, the first included; 1 disables retries
+	BaseDelay   time.Duration // Wait before the first retry, doubled for each one after
+	MaxDelay    time.Duration // Cap on the wait
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   10 * time.Second,
+	MaxDelay:    5 * time.Minute,
+}
+
+// delay is the wait after the given number of failed attempts
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempts && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// SetRetryPolicy changes how failed downloads are retried. Retries already
+// scheduled keep their time.
+func (m *Manager) SetRetryPolicy(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	m.mu.Lock()
+	m.retryPolicy = p
+	m.mu.Unlock()
+}
+
+// failDownload marks item as failed and, if the error may be transient and
+// attempts are left, schedules another download. The item stays in
+// StatusError until then so playback skips it. m.mu must be locked.
+func (m *Manager) failDownload(item *QueueItem, err error) {
+	item.Status = StatusError
+	item.Error = err.Error()
+
+	if !downloader.IsRetryable(err) {
+		log.Printf("Not retrying %s: permanent error", item.Title)
+		return
+	}
+	if item.Attempts >= m.retryPolicy.MaxAttempts {
+		log.Printf("Giving up on %s after %d attempts", item.Title, item.Attempts)
+		return
+	}
+
+	delay := m.retryPolicy.delay(item.Attempts)
+	retryAt := time.Now().Add(delay)
+	item.RetryAt = &retryAt
+	log.Printf("Retrying %s in %s (attempt %d of %d)", item.Title, delay, item.Attempts+1, m.retryPolicy.MaxAttempts)
+
+	item.retry = time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if item.RetryAt == nil || item.Status != StatusError || m.indexOf(item) == -1 {
+			// Retried by hand, or removed from the queue
+			return
+		}
+		m.resetForRetry(item)
+		m.enqueue(item)
+		m.notify(ChangeQueue)
+	})
+}
+
+// resetForRetry makes a failed item pending again. Local files are checked
+// again instead. m.mu must be locked.
+func (m *Manager) resetForRetry(item *QueueItem) {
+	if item.retry != nil {
+		item.retry.Stop()
+		item.retry = nil
+	}
+	item.RetryAt = nil
+
+	if path, ok, err := m.localFile(item.URL); ok {
+		if err != nil || fileMissing(path) {
+			return // Still missing or not allowed, keep the error
+		}
+		item.LocalPath = path
+		item.Status = StatusReady
+		item.Error = ""
+		return
+	}
+	item.Status = StatusPending
+}
+
+// Retry downloads a failed item again, with a fresh attempt count. An empty
+// queue id retries every failed item. It returns how many were retried.
+func (m *Manager) Retry(queueID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []*QueueItem
+	if queueID == "" {
+		for _, item := range m.queue {
+			if item.Status == StatusError {
+				items = append(items, item)
+			}
+		}
+	} else {
+		item, _ := m.itemByQueueID(queueID)
+		if item == nil {
+			return 0, ErrItemNotFound
+		}
+		if item.Status != StatusError {
+			return 0, ErrNotFailed
+		}
+		items = append(items, item)
+	}
+
+	for _, item := range items {
+		item.Attempts = 0
+		m.resetForRetry(item)
+		m.enqueue(item)
+	}
+	if len(items) > 0 {
+		m.notify(ChangeQueue)
+	}
+	return len(items), nil
+}
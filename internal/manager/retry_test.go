@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"errors"
+	"kaboomer/internal/ytdlptest"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Second, MaxDelay: 5 * time.Minute}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{5, 160 * time.Second},
+		{6, 5 * time.Minute}, // 320s, capped
+		{50, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.attempts); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// fastRetries retries quickly enough for tests
+var fastRetries = RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond}
+
+// queueItem returns a copy of the only queue item
+func queueItem(t *testing.T, m *Manager) QueueItem {
+	t.Helper()
+	queue := m.GetQueue()
+	if len(queue) != 1 {
+		t.Fatalf("queue holds %d items, want 1", len(queue))
+	}
+	return queue[0]
+}
+
+func TestRetryTransient(t *testing.T) {
+	m, ytdlp := newDownloadManager(t, ytdlptest.Script{Stderr: "ERROR: unable to download video data: HTTP Error 503: Service Unavailable\n", Exit: 1})
+	m.SetRetryPolicy(fastRetries)
+
+	m.Add("https://www.youtube.com/watch?v=a1", "A", "a1", "")
+	eventually(t, "A to give up", func() bool {
+		item := queueItem(t, m)
+		return item.Status == StatusError && item.Attempts == fastRetries.MaxAttempts && item.RetryAt == nil
+	})
+	time.Sleep(50 * time.Millisecond)
+	if n := len(ytdlp.Calls(t)); n != fastRetries.MaxAttempts {
+		t.Errorf("yt-dlp ran %d times, want %d", n, fastRetries.MaxAttempts)
+	}
+
+	// A manual retry starts over, and succeeds once the network is back
+	ytdlp.Set(t, ytdlptest.Script{Download: &ytdlptest.Download{Size: 10}})
+	n, err := m.Retry(queueItem(t, m).QueueID)
+	if err != nil || n != 1 {
+		t.Fatalf("Retry() = %d, %v; want 1", n, err)
+	}
+	eventually(t, "A to download", func() bool {
+		return queueItem(t, m).Status == StatusReady
+	})
+	if item := queueItem(t, m); item.Attempts != 0 || item.Error != "" {
+		t.Errorf("ready item has %d attempts, error %q; want them cleared", item.Attempts, item.Error)
+	}
+}
+
+func TestRetrySchedules(t *testing.T) {
+	m, ytdlp := newDownloadManager(t, ytdlptest.Script{Stderr: "ERROR: HTTP Error 429: Too Many Requests\n", Exit: 1})
+	m.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	before := time.Now()
+	m.Add("https://www.youtube.com/watch?v=a1", "A", "a1", "")
+	eventually(t, "A to fail", func() bool {
+		return queueItem(t, m).Status == StatusError
+	})
+	item := queueItem(t, m)
+	if item.RetryAt == nil || item.RetryAt.Before(before.Add(time.Hour)) {
+		t.Errorf("retry at %v, want an hour from now", item.RetryAt)
+	}
+
+	// Retrying everything doesn't wait for the scheduled retry
+	ytdlp.Set(t, ytdlptest.Script{Download: &ytdlptest.Download{Size: 10}})
+	if n, err := m.Retry(""); n != 1 || err != nil {
+		t.Fatalf("Retry() = %d, %v; want 1", n, err)
+	}
+	eventually(t, "A to download", func() bool {
+		return queueItem(t, m).Status == StatusReady
+	})
+	if item := queueItem(t, m); item.RetryAt != nil {
+		t.Errorf("ready item still has a retry at %v", item.RetryAt)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	m, ytdlp := newDownloadManager(t, ytdlptest.Script{Stderr: "ERROR: [youtube] a1: Private video. Sign in if you've been granted access\n", Exit: 1})
+	m.SetRetryPolicy(fastRetries)
+
+	m.Add("https://www.youtube.com/watch?v=a1", "A", "a1", "")
+	eventually(t, "A to fail", func() bool {
+		return queueItem(t, m).Status == StatusError
+	})
+	time.Sleep(50 * time.Millisecond)
+	if item := queueItem(t, m); item.RetryAt != nil {
+		t.Errorf("permanent failure scheduled a retry at %v", item.RetryAt)
+	}
+	if n := len(ytdlp.Calls(t)); n != 1 {
+		t.Errorf("yt-dlp ran %d times, want 1", n)
+	}
+}
+
+func TestRetryErrors(t *testing.T) {
+	m, _ := newTestManager(t)
+	addFiles(t, m, "A")
+
+	if _, err := m.Retry("missing"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Retry of a missing item = %v, want ErrItemNotFound", err)
+	}
+	if _, err := m.Retry(queueItem(t, m).QueueID); !errors.Is(err, ErrNotFailed) {
+		t.Errorf("Retry of a ready item = %v, want ErrNotFailed", err)
+	}
+	if n, err := m.Retry(""); n != 0 || err != nil {
+		t.Errorf("Retry of everything = %d, %v; want nothing retried", n, err)
+	}
+}
@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Download priorities, lower runs first
+const (
+	priorityPlayNow = iota // The item the user is waiting to hear
+	priorityNextUp         // The item that plays after the current one
+	priorityQueued         // Everything else, in the order it was queued
+)
+
+type downloadJob struct {
+	item     *QueueItem
+	priority int
+	seq      uint64 // Keeps FIFO order within a priority
+	index    int    // Position in the heap
+}
+
+// jobHeap implements heap.Interface ordered by priority, then arrival
+type jobHeap []*downloadJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *jobHeap) Push(x any) {
+	job := x.(*downloadJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+func (h *jobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+// scheduler runs downloads from a priority queue with bounded concurrency.
+// Every running download gets its own context so it can be cancelled when
+// its item leaves the queue.
+type scheduler struct {
+	mu      sync.Mutex
+	jobs    jobHeap
+	queued  map[*QueueItem]*downloadJob
+	running map[*QueueItem]context.CancelFunc
+	limit   int
+	seq     uint64
+	wake    chan struct{}
+	run     func(ctx context.Context, item *QueueItem)
+}
+
+func newScheduler(limit int, run func(ctx context.Context, item *QueueItem)) *scheduler {
+	if limit < 1 {
+		limit = 1
+	}
+	return &scheduler{
+		queued:  make(map[*QueueItem]*downloadJob),
+		running: make(map[*QueueItem]context.CancelFunc),
+		limit:   limit,
+		wake:    make(chan struct{}, 1),
+		run:     run,
+	}
+}
+
+// loop starts jobs whenever a slot frees up or new work arrives
+func (s *scheduler) loop() {
+	for range s.wake {
+		s.dispatch()
+	}
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.running) < s.limit && s.jobs.Len() > 0 {
+		job := heap.Pop(&s.jobs).(*downloadJob)
+		delete(s.queued, job.item)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s.running[job.item] = cancel
+		go func(item *QueueItem) {
+			s.run(ctx, item)
+			cancel()
+
+			s.mu.Lock()
+			delete(s.running, item)
+			s.mu.Unlock()
+			s.signal()
+		}(job.item)
+	}
+}
+
+// push queues a download, or raises the priority of one already waiting.
+// It never blocks.
+func (s *scheduler) push(item *QueueItem, priority int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.running[item]; ok {
+		return
+	}
+	if job, ok := s.queued[item]; ok {
+		if priority < job.priority {
+			job.priority = priority
+			heap.Fix(&s.jobs, job.index)
+		}
+		return
+	}
+
+	s.seq++
+	job := &downloadJob{item: item, priority: priority, seq: s.seq}
+	heap.Push(&s.jobs, job)
+	s.queued[item] = job
+	s.signal()
+}
+
+// cancel drops a waiting download or kills a running one
+func (s *scheduler) cancel(item *QueueItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.queued[item]; ok {
+		heap.Remove(&s.jobs, job.index)
+		delete(s.queued, item)
+	}
+	if cancel, ok := s.running[item]; ok {
+		cancel()
+	}
+}
+
+// setLimit changes how many downloads run at once
+func (s *scheduler) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+	s.signal()
+}
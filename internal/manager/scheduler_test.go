@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testJobs runs scheduler jobs that report when they start and block until
+// released or cancelled
+type testJobs struct {
+	started chan string
+	release chan struct{}
+}
+
+func newTestJobs() *testJobs {
+	return &testJobs{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (j *testJobs) run(ctx context.Context, item *QueueItem) {
+	j.started <- item.Title
+	select {
+	case <-j.release:
+	case <-ctx.Done():
+	}
+}
+
+// next returns the title of the next job to start
+func (j *testJobs) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case title := <-j.started:
+		return title
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a job to start")
+		return ""
+	}
+}
+
+// idle fails if a job starts within a short while
+func (j *testJobs) idle(t *testing.T) {
+	t.Helper()
+	select {
+	case title := <-j.started:
+		t.Errorf("%s started", title)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	jobs := newTestJobs()
+	close(jobs.release) // Every job finishes at once
+	s := newScheduler(1, jobs.run)
+
+	items := make(map[string]*QueueItem)
+	for _, title := range []string{"queued1", "queued2", "next", "now", "raised"} {
+		items[title] = &QueueItem{Title: title}
+	}
+	s.push(items["queued1"], priorityQueued)
+	s.push(items["raised"], priorityQueued)
+	s.push(items["queued2"], priorityQueued)
+	s.push(items["next"], priorityNextUp)
+	s.push(items["now"], priorityPlayNow)
+	s.push(items["raised"], priorityNextUp)
+	s.push(items["now"], priorityQueued) // Never lowered
+
+	// Nothing runs until the loop does, so the whole queue is sorted
+	go s.loop()
+	var got []string
+	for range items {
+		got = append(got, jobs.next(t))
+	}
+	// A raised job keeps its place among those it joins
+	want := []string{"now", "raised", "next", "queued1", "queued2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("jobs ran in order %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	jobs := newTestJobs()
+	s := newScheduler(1, jobs.run)
+	go s.loop()
+
+	a, b, c := &QueueItem{Title: "a"}, &QueueItem{Title: "b"}, &QueueItem{Title: "c"}
+	s.push(a, priorityQueued)
+	if got := jobs.next(t); got != "a" {
+		t.Fatalf("%s started first, want a", got)
+	}
+	s.push(b, priorityQueued)
+	s.push(a, priorityPlayNow) // Already running, no second run
+
+	// Waiting and running jobs alike
+	s.cancel(b)
+	s.cancel(a)
+	s.push(c, priorityQueued)
+	if got := jobs.next(t); got != "c" {
+		t.Errorf("%s started after cancelling a and b, want c", got)
+	}
+	s.cancel(c)
+	jobs.idle(t)
+}
+
+func TestSchedulerSetLimit(t *testing.T) {
+	jobs := newTestJobs()
+	s := newScheduler(0, jobs.run) // At least one
+	go s.loop()
+
+	for _, title := range []string{"a", "b", "c", "d"} {
+		s.push(&QueueItem{Title: title}, priorityQueued)
+	}
+	if got := jobs.next(t); got != "a" {
+		t.Fatalf("%s started first, want a", got)
+	}
+	jobs.idle(t)
+
+	s.setLimit(3)
+	got := []string{jobs.next(t), jobs.next(t)}
+	slices.Sort(got) // Started together, either may report first
+	if !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("raising the limit started %v, want b and c", got)
+	}
+	jobs.idle(t)
+
+	// Lowering it lets running jobs finish, then runs one at a time
+	s.setLimit(1)
+	jobs.release <- struct{}{}
+	jobs.idle(t)
+	jobs.release <- struct{}{}
+	jobs.idle(t)
+	jobs.release <- struct{}{}
+	if got := jobs.next(t); got != "d" {
+		t.Errorf("%s started, want d", got)
+	}
+	jobs.release <- struct{}{}
+}
@@ -0,0 +1,245 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"kaboomer/internal/atomicfile"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// saveDelay batches bursts of queue changes (e.g. a batch add) into one write
+	saveDelay = 2 * time.Second
+	// positionSaveInterval bounds how much playback position is lost on a crash
+	positionSaveInterval = 15 * time.Second
+)
+
+// savedState is the on-disk form of the queue
+type savedState struct {
+	Queue    []savedItem `json:"queue"`
+	Current  int         `json:"current"`
+	Position float64     `json:"position"`
+	SavedAt  time.Time   `json:"saved_at"`
+}
+
+type savedItem struct {
+	QueueID   string      `json:"queue_id"`
+	ID        string      `json:"id"`
+	URL       string      `json:"url"`
+	Title     string      `json:"title"`
+	Artist    string      `json:"artist,omitempty"`
+	Status    TrackStatus `json:"status"`
+	LocalPath string      `json:"local_path,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// RestoreState loads the queue saved at path and keeps the file updated from then on.
+// A missing file is not an error. Items whose file is still cached come back ready;
+// the rest are downloaded again. If resume is set, the current item starts
+// playing from the saved position.
+//
+// A file that can't be parsed is moved aside to path+".corrupt" before saving
+// starts, and one that can't be read is left alone and not saved over.
+func (m *Manager) RestoreState(path string, resume bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		m.startAutosave(path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state, not saving the queue: %w", err)
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		corrupt := path + ".corrupt"
+		if renameErr := os.Rename(path, corrupt); renameErr != nil {
+			return fmt.Errorf("failed to parse state, not saving the queue: %w", err)
+		}
+		m.startAutosave(path)
+		return fmt.Errorf("failed to parse state, moved it to %s: %w", corrupt, err)
+	}
+	defer m.startAutosave(path)
+
+	m.mu.Lock()
+	var pending []*QueueItem
+	for i, saved := range state.Queue {
+		if saved.QueueID == "" {
+			saved.QueueID = newQueueID()
+		}
+		item := &QueueItem{
+			QueueID:   saved.QueueID,
+			ID:        saved.ID,
+			URL:       saved.URL,
+			Title:     saved.Title,
+			Artist:    saved.Artist,
+			Status:    saved.Status,
+			LocalPath: saved.LocalPath,
+			Error:     saved.Error,
+		}
+		m.restoreStatus(item)
+		if item.Status == StatusPending {
+			pending = append(pending, item)
+		}
+		m.queue = append(m.queue, item)
+		if i == state.Current {
+			m.current = item
+		}
+	}
+
+	if resume && m.current != nil {
+		if m.current.playable() {
+			log.Printf("Resuming %s at %.0fs", m.current.Title, state.Position)
+			if err := m.resumeItem(m.current, state.Position); err != nil {
+				log.Printf("Failed to resume %s: %v", m.current.Title, err)
+			}
+		} else if m.current.Status == StatusPending {
+			// The file is gone from the cache, start it from the top once downloaded
+			m.playTarget = m.current
+		}
+	}
+	for _, item := range pending {
+		m.enqueue(item)
+	}
+	m.mu.Unlock()
+
+	log.Printf("Restored %d queue items (%d to download)", len(state.Queue), len(pending))
+	m.notify(ChangeQueue)
+	m.notify(ChangeStatus)
+	return nil
+}
+
+// restoreStatus maps a saved status onto what is true after a restart.
+// m.mu must be locked.
+func (m *Manager) restoreStatus(item *QueueItem) {
+	cached := false
+	if item.LocalPath != "" {
+		if _, err := os.Stat(item.LocalPath); err == nil {
+			cached = true
+		}
+	}
+
+	switch {
+	case item.Status == StatusError:
+		// Keep the error visible, the user can decide what to do with it
+	case !cached:
+		item.Status = StatusPending
+		item.LocalPath = ""
+		item.Error = ""
+	case item.Status == StatusPlayed:
+		// Already heard it
+	default:
+		// Playing, ready, or interrupted mid-download with a finished file
+		item.Status = StatusReady
+	}
+}
+
+// resumeItem loads a restored item into mpv at the given position.
+// m.mu must be locked.
+func (m *Manager) resumeItem(item *QueueItem, position float64) error {
+	m.forgetPreload()
+	m.applyGain(item)
+	m.applyFade(0)
+	id, err := m.player.Load(item.LocalPath, item.Title, position)
+	if err != nil {
+		return err
+	}
+	item.entryID = id
+	m.setCurrent(item)
+	return nil
+}
+
+// SaveState writes the queue, the current item and the playback position to the
+// state file. It is a no-op until RestoreState has set the path, and when
+// nothing changed since the last save, e.g. while paused.
+func (m *Manager) SaveState() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	path := m.statePath
+	if path == "" {
+		m.mu.Unlock()
+		return nil
+	}
+	state := savedState{
+		Queue:   make([]savedItem, len(m.queue)),
+		Current: m.currentIndex(),
+	}
+	for i, item := range m.queue {
+		state.Queue[i] = savedItem{
+			QueueID:   item.QueueID,
+			ID:        item.ID,
+			URL:       item.URL,
+			Title:     item.Title,
+			Artist:    item.Artist,
+			Status:    item.Status,
+			LocalPath: item.LocalPath,
+			Error:     item.Error,
+		}
+	}
+	m.mu.Unlock()
+
+	if state.Current != -1 {
+		if pos, err := m.player.GetProperty("time-pos"); err == nil {
+			if posFloat, ok := pos.(float64); ok {
+				state.Position = posFloat
+			}
+		}
+	}
+	if last := m.lastSaved; last != nil && last.Current == state.Current &&
+		last.Position == state.Position && slices.Equal(last.Queue, state.Queue) {
+		return nil
+	}
+	state.SavedAt = time.Now()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Never leaves a half-written state, even on a power cut
+	if err := atomicfile.Write(path, data); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	m.lastSaved = &state
+	return nil
+}
+
+// startAutosave saves the state shortly after queue changes and periodically
+// while something is loaded, so the position survives a crash.
+func (m *Manager) startAutosave(path string) {
+	m.mu.Lock()
+	m.statePath = path
+	m.mu.Unlock()
+
+	changes, _ := m.Subscribe()
+	go func() {
+		ticker := time.NewTicker(positionSaveInterval)
+		defer ticker.Stop()
+
+		var delay <-chan time.Time
+		for {
+			select {
+			case kind := <-changes:
+				// Not ChangeProgress, that would rewrite the file all through a download
+				if kind == ChangeQueue && delay == nil {
+					delay = time.After(saveDelay)
+				}
+				continue
+			case <-delay:
+				delay = nil
+			case <-ticker.C:
+				if idle, ok := m.player.Observed("idle-active"); ok && idle == true {
+					continue
+				}
+			}
+			if err := m.SaveState(); err != nil {
+				log.Printf("State save error: %v", err)
+			}
+		}
+	}()
+}
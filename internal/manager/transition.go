@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+)
+
+// maxCrossfade bounds the fade between tracks, in seconds
+const maxCrossfade = 12
+
+// Transition is how one queue item gives way to the next.
+//
+// Gapless preloads the next item into mpv while the current one plays, so
+// mpv moves on by itself without reopening the audio output. Crossfade fades
+// each item out over its last seconds and the next one in over its first;
+// mpv plays one file at a time, so the two don't overlap.
+type Transition struct {
+	Gapless   bool    `json:"gapless"`
+	Crossfade float64 `json:"crossfade"` // Seconds, 0 for a hard cut
+}
+
+// SetTransition changes how items follow each other, from the next
+// transition on
+func (m *Manager) SetTransition(t Transition) error {
+	if t.Crossfade < 0 || t.Crossfade > maxCrossfade {
+		return fmt.Errorf("crossfade must be between 0 and %d seconds", maxCrossfade)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transition = t
+	m.syncPreload()
+	if m.current != nil && m.current.Status == StatusPlaying {
+		m.applyFade(m.currentDuration())
+	}
+	m.notify(ChangeStatus)
+	return nil
+}
+
+// GetTransition returns the transition setting
+func (m *Manager) GetTransition() Transition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.transition
+}
+
+// applyFade sets the fades for the item playing, which is duration seconds
+// long, 0 if not known yet. m.mu must be locked.
+func (m *Manager) applyFade(duration float64) {
+	if err := m.player.SetFade(m.transition.Crossfade, duration); err != nil {
+		log.Printf("Failed to set crossfade: %v", err)
+	}
+}
+
+// currentDuration returns the length mpv reports for the file playing, or 0
+func (m *Manager) currentDuration() float64 {
+	if d, ok := m.player.Observed("duration"); ok {
+		if dFloat, ok := d.(float64); ok {
+			return dFloat
+		}
+	}
+	return 0
+}
+
+// handleDuration updates the fade out once mpv knows how long the file is
+func (m *Manager) handleDuration(data interface{}) {
+	duration, ok := data.(float64)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != nil && m.current.Status == StatusPlaying {
+		m.applyFade(duration)
+	}
+}
+
+// autoNext returns the item advance would start when the current one ends,
+// if it can be preloaded: on disk, not the current item again, and not
+// waiting on a play target. m.mu must be locked.
+func (m *Manager) autoNext() *QueueItem {
+	if m.current == nil || m.mode == ModeRepeatOne || m.playTarget != nil {
+		return nil
+	}
+	order := m.playOrder()
+	pos := orderIndex(order, m.current)
+	if pos == -1 {
+		return nil
+	}
+
+	n := len(order)
+	for step := 1; step < n; step++ {
+		i := pos + step
+		if i >= n {
+			if m.mode != ModeRepeatAll {
+				return nil
+			}
+			i -= n
+		}
+		next := order[i]
+		if next.Status == StatusError {
+			continue
+		}
+		if next.playable() {
+			return next
+		}
+		return nil // Becomes the play target instead
+	}
+	return nil
+}
+
+// syncPreload makes sure mpv has the item that plays next preloaded in gapless
+// mode, and nothing otherwise. It runs when the current item, the queue order
+// or the mode changes, and on every playback poll to catch downloads finishing.
+// m.mu must be locked.
+func (m *Manager) syncPreload() {
+	var want *QueueItem
+	if m.transition.Gapless && m.current != nil && m.current.Status == StatusPlaying {
+		want = m.autoNext()
+	}
+	if want == m.preloaded && (want == nil || want.entryID != 0) {
+		return
+	}
+
+	if m.preloaded != nil {
+		if err := m.player.ClearPreload(); err != nil {
+			log.Printf("Failed to drop preloaded %s: %v", m.preloaded.Title, err)
+		}
+		m.forgetPreload()
+	}
+	if want == nil {
+		return
+	}
+	id, err := m.player.Preload(want.LocalPath, want.Title)
+	if err != nil {
+		log.Printf("Failed to preload %s: %v", want.Title, err)
+		return
+	}
+	want.entryID = id
+	m.preloaded = want
+}
+
+// forgetPreload stops tracking the preloaded item, so mpv starting it is no
+// longer taken for it playing. m.mu must be locked.
+func (m *Manager) forgetPreload() {
+	if m.preloaded != nil && m.preloaded != m.current {
+		m.preloaded.entryID = 0
+	}
+	m.preloaded = nil
+}
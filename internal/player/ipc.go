@@ -0,0 +1,199 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// requestTimeout bounds how long a caller waits for mpv to answer a request
+const requestTimeout = 5 * time.Second
+
+// EventType is the name of an mpv event as sent over the IPC socket
+type EventType string
+
+const (
+	EventStartFile      EventType = "start-file"
+	EventEndFile        EventType = "end-file"
+	EventPropertyChange EventType = "property-change"
+)
+
+// End-file reasons reported by mpv
+const (
+	EndReasonEOF      = "eof"
+	EndReasonStop     = "stop"
+	EndReasonQuit     = "quit"
+	EndReasonError    = "error"
+	EndReasonRedirect = "redirect"
+)
+
+// Event is a typed mpv event delivered to subscribers
+type Event struct {
+	Type            EventType
+	PlaylistEntryID int
+	Reason          string      // end-file only
+	FileError       string      // end-file only, set when Reason is "error"
+	Property        string      // property-change only
+	Data            interface{} // property-change only, nil when unavailable
+}
+
+// ipcMessage is the union of everything mpv writes on the socket:
+// replies carry request_id, events carry event.
+type ipcMessage struct {
+	RequestID       *int        `json:"request_id"`
+	Error           string      `json:"error"`
+	Data            interface{} `json:"data"`
+	Event           string      `json:"event"`
+	Name            string      `json:"name"`
+	Reason          string      `json:"reason"`
+	FileError       string      `json:"file_error"`
+	PlaylistEntryID int         `json:"playlist_entry_id"`
+}
+
+type ipcResponse struct {
+	data interface{}
+	err  error
+}
+
+// ipcConn is a single long-lived connection to the mpv socket.
+// A reader goroutine routes replies to waiting callers by request_id
+// and hands events to the owning Player.
+type ipcConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[int]chan ipcResponse
+	closed  bool
+	done    chan struct{}
+}
+
+func dialIPC(socketPath string, onEvent func(Event)) (*ipcConn, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &ipcConn{
+		conn:    conn,
+		pending: make(map[int]chan ipcResponse),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop(onEvent)
+	return c, nil
+}
+
+func (c *ipcConn) readLoop(onEvent func(Event)) {
+	scanner := bufio.NewScanner(c.conn)
+	// Playlists and track lists can produce long lines
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		var msg ipcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("MPV IPC Decode Error: %v", err)
+			continue
+		}
+
+		if msg.Event != "" {
+			onEvent(Event{
+				Type:            EventType(msg.Event),
+				PlaylistEntryID: msg.PlaylistEntryID,
+				Reason:          msg.Reason,
+				FileError:       msg.FileError,
+				Property:        msg.Name,
+				Data:            msg.Data,
+			})
+			continue
+		}
+
+		if msg.RequestID == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[*msg.RequestID]
+		delete(c.pending, *msg.RequestID)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		resp := ipcResponse{data: msg.Data}
+		if msg.Error != "" && msg.Error != "success" {
+			resp.err = fmt.Errorf("mpv error: %s", msg.Error)
+		}
+		ch <- resp
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = fmt.Errorf("mpv closed the connection")
+	}
+	c.shutdown(err)
+}
+
+// shutdown fails every outstanding request and marks the connection unusable
+func (c *ipcConn) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.conn.Close()
+	for id, ch := range c.pending {
+		ch <- ipcResponse{err: err}
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+func (c *ipcConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// request writes a command tagged with reqID and waits for the matching reply
+func (c *ipcConn) request(reqID int, command []interface{}) (interface{}, error) {
+	payload := map[string]interface{}{
+		"command":    command,
+		"request_id": reqID,
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+
+	ch := make(chan ipcResponse, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("mpv connection closed")
+	}
+	c.pending[reqID] = ch
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	_, err = c.conn.Write(data)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.shutdown(err)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.data, resp.err
+	case <-time.After(requestTimeout):
+		c.mu.Lock()
+		delete(c.pending, reqID)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("timed out waiting for mpv reply to %v", command[0])
+	}
+}
@@ -0,0 +1,94 @@
+package player
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// levelFilter labels the filter that carries the normalisation gain and the
+// fades in mpv's audio filter chain. It is added once and then changed with
+// af-command, which doesn't rebuild the chain, so a gapless transition stays
+// gapless when the next file needs a different gain.
+const levelFilter = "@kaboomer-level"
+
+// level is what levelFilter should do to the file playing
+type level struct {
+	gain       float64 // dB
+	fadeLength float64 // Seconds faded in at the start and out at the end, 0 for none
+	fadeEnd    float64 // Where the file ends, 0 if unknown
+	applied    string  // Volume expression mpv has, "" if the filter isn't there
+}
+
+// expression is the volume expression for lavfi's volume filter, evaluated
+// per frame; t is the frame's position in the file
+func (l level) expression() string {
+	factor := strconv.FormatFloat(math.Pow(10, l.gain/20), 'f', 4, 64)
+	if l.fadeLength <= 0 {
+		return factor
+	}
+	length := strconv.FormatFloat(l.fadeLength, 'f', 3, 64)
+	fade := fmt.Sprintf("t/%s", length)
+	if l.fadeEnd > l.fadeLength {
+		end := strconv.FormatFloat(l.fadeEnd, 'f', 3, 64)
+		fade = fmt.Sprintf("min(t/%s,(%s-t)/%s)", length, end, length)
+	}
+	return fmt.Sprintf("%s*if(isnan(t),1,clip(%s,0,1))", factor, fade)
+}
+
+// SetGain amplifies or attenuates what plays by db decibels, on top of the
+// volume setting
+func (p *Player) SetGain(db float64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	l := p.level
+	l.gain = math.Round(db*100) / 100
+	return p.applyLevel(l)
+}
+
+// SetFade fades the file playing in over its first length seconds and, if
+// end is known, out over the length seconds before end. length 0 turns the
+// fades off. mpv plays one file at a time, so a crossfade between two files
+// is the first fading out followed by the second fading in.
+func (p *Player) SetFade(length, end float64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	l := p.level
+	l.fadeLength = max(length, 0)
+	l.fadeEnd = max(end, 0)
+	if l.fadeLength == 0 {
+		l.fadeEnd = 0
+	}
+	return p.applyLevel(l)
+}
+
+// applyLevel brings mpv's levelFilter in line with l. p.mutex must be locked.
+func (p *Player) applyLevel(l level) error {
+	expr := l.expression()
+	if expr == l.applied {
+		p.level = l
+		return nil
+	}
+	if l.applied == "" {
+		if l.gain == 0 && l.fadeLength == 0 {
+			// Nothing to do, no need for the filter yet
+			p.level = l
+			return nil
+		}
+		filter := levelFilter + ":lavfi-volume=volume=1:eval=frame"
+		if err := p.sendCommand([]interface{}{"af", "add", filter}); err != nil {
+			return err
+		}
+		l.applied = "1"
+		p.level.applied = "1"
+	}
+	// af-command takes the label without its @
+	if err := p.sendCommand([]interface{}{"af-command", levelFilter[1:], "volume", expr}); err != nil {
+		return err
+	}
+	l.applied = expr
+	p.level = l
+	return nil
+}
@@ -0,0 +1,636 @@
+// Package mpvtest provides a fake mpv for tests. It speaks mpv's JSON IPC
+// protocol on a real unix socket, so a player.Player attached to it behaves
+// as it would against mpv, without an mpv binary or audio hardware.
+//
+// The fake keeps a playlist, an audio filter chain and a handful of
+// properties, answers loadfile, playlist-play-index, playlist-clear, stop,
+// get_property, set_property, observe_property, cycle, seek, af and
+// af-command, and sends start-file, end-file and property-change events the
+// way mpv does. Tests drive playback with Finish and Fail, and simulate a
+// crash with Restart.
+package mpvtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Entry is one file in the fake playlist
+type Entry struct {
+	ID       int
+	Filename string
+	Title    string // force-media-title, if given
+}
+
+// Server is a fake mpv listening on SocketPath
+type Server struct {
+	SocketPath string
+
+	ln net.Listener
+
+	mu             sync.Mutex
+	clients        map[*client]bool
+	playlist       []Entry
+	current        int // Index into playlist, -1 when idle
+	nextID         int
+	props          map[string]interface{}
+	commands       [][]interface{}
+	filters        []string          // Audio filter chain, as given to the af command
+	filterCommands map[string]string // Last af-command argument by "@label command"
+	closed         bool
+	wg             sync.WaitGroup
+}
+
+// client is one IPC connection and the properties it observes
+type client struct {
+	conn     net.Conn
+	writeMu  sync.Mutex
+	observed map[string]int // Property name to observe id
+}
+
+// New starts a fake mpv on a socket in a temporary directory. It is shut
+// down when the test ends.
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	// Unix socket paths are short on most systems, t.TempDir can be too long
+	dir, err := os.MkdirTemp("", "mpvtest")
+	if err != nil {
+		t.Fatalf("mpvtest: %v", err)
+	}
+	path := filepath.Join(dir, "mpv.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("mpvtest: %v", err)
+	}
+
+	s := &Server{
+		SocketPath:     path,
+		ln:             ln,
+		clients:        make(map[*client]bool),
+		filterCommands: make(map[string]string),
+		current:        -1,
+		props:          initialProps(),
+	}
+	s.wg.Add(1)
+	go s.accept()
+
+	t.Cleanup(func() {
+		s.Close()
+		os.RemoveAll(dir)
+	})
+	return s
+}
+
+// Close stops listening and drops every connection
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.ln.Close()
+	s.Disconnect()
+	s.wg.Wait()
+}
+
+// Disconnect drops every connection but keeps listening, like an mpv that
+// closed its IPC clients
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+		delete(s.clients, c)
+	}
+}
+
+// Restart drops every connection and forgets the playlist, filters and
+// properties, like an mpv that crashed and was started again on the same
+// socket. Entry ids start over.
+func (s *Server) Restart() {
+	s.mu.Lock()
+	s.playlist = nil
+	s.current = -1
+	s.nextID = 0
+	s.filters = nil
+	s.filterCommands = make(map[string]string)
+	s.props = initialProps()
+	s.mu.Unlock()
+
+	s.Disconnect()
+}
+
+// initialProps returns the properties of a freshly started mpv
+func initialProps() map[string]interface{} {
+	return map[string]interface{}{
+		"pause":       false,
+		"volume":      100.0,
+		"idle-active": true,
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, observed: make(map[string]int)}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// request is a command as sent by an IPC client
+type request struct {
+	Command   []interface{} `json:"command"`
+	RequestID *int          `json:"request_id"`
+}
+
+func (s *Server) serve(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || len(req.Command) == 0 {
+			c.send(map[string]interface{}{"error": "invalid parameter"})
+			continue
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, req.Command)
+		data, events, err := s.handle(c, req.Command)
+		s.mu.Unlock()
+
+		reply := map[string]interface{}{"error": "success", "data": data}
+		if err != nil {
+			reply = map[string]interface{}{"error": err.Error()}
+		}
+		if req.RequestID != nil {
+			reply["request_id"] = *req.RequestID
+		}
+		// mpv answers first and sends the resulting events after
+		c.send(reply)
+		s.broadcast(events)
+	}
+}
+
+// event is a message for every client; property changes only go to the
+// clients observing the property
+type event map[string]interface{}
+
+// handle runs one command and returns the reply data and the events it
+// caused. s.mu must be locked.
+func (s *Server) handle(c *client, command []interface{}) (interface{}, []event, error) {
+	name, _ := command[0].(string)
+	args := command[1:]
+
+	switch name {
+	case "loadfile":
+		if len(args) < 1 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		filename, _ := args[0].(string)
+		mode := "replace"
+		if len(args) > 1 {
+			mode, _ = args[1].(string)
+		}
+		var opts string
+		if len(args) > 2 {
+			opts, _ = args[2].(string)
+		}
+		return s.loadfile(filename, mode, opts)
+
+	case "playlist-play-index":
+		if len(args) < 1 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		if args[0] == "none" {
+			return nil, s.stop(), nil
+		}
+		index, ok := args[0].(float64)
+		if !ok || int(index) < 0 || int(index) >= len(s.playlist) {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		events := s.end("stop", "")
+		return nil, append(events, s.start(int(index), 0)...), nil
+
+	case "playlist-clear":
+		// Everything but the file playing
+		if s.current == -1 {
+			s.playlist = nil
+		} else {
+			s.playlist = []Entry{s.playlist[s.current]}
+			s.current = 0
+		}
+		return nil, nil, nil
+
+	case "stop":
+		events := s.stop()
+		s.playlist = nil
+		return nil, events, nil
+
+	case "get_property":
+		if len(args) < 1 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		prop, _ := args[0].(string)
+		if prop == "playlist" {
+			return s.playlistProperty(), nil, nil
+		}
+		val, ok := s.props[prop]
+		if !ok {
+			return nil, nil, fmt.Errorf("property unavailable")
+		}
+		return val, nil, nil
+
+	case "set_property":
+		if len(args) < 2 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		prop, _ := args[0].(string)
+		return nil, s.set(prop, args[1]), nil
+
+	case "observe_property":
+		if len(args) < 2 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		id, _ := args[0].(float64)
+		prop, _ := args[1].(string)
+		c.observed[prop] = int(id)
+		// mpv reports the current value right away
+		return nil, []event{s.propertyEvent(prop)}, nil
+
+	case "cycle":
+		if len(args) < 1 || args[0] != "pause" {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		paused, _ := s.props["pause"].(bool)
+		return nil, s.set("pause", !paused), nil
+
+	case "af":
+		if len(args) < 1 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		op, _ := args[0].(string)
+		var filter string
+		if len(args) > 1 {
+			filter, _ = args[1].(string)
+		}
+		return nil, nil, s.af(op, filter)
+
+	case "af-command":
+		if len(args) < 3 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		label, _ := args[0].(string)
+		cmd, _ := args[1].(string)
+		arg, _ := args[2].(string)
+		return nil, nil, s.afCommand(label, cmd, arg)
+
+	case "seek":
+		if len(args) < 1 || s.current == -1 {
+			return nil, nil, fmt.Errorf("invalid parameter")
+		}
+		pos, _ := args[0].(float64)
+		return nil, s.set("time-pos", pos), nil
+	}
+	return nil, nil, fmt.Errorf("invalid parameter")
+}
+
+// loadfile adds a file and, for replace, plays it. s.mu must be locked.
+func (s *Server) loadfile(filename, mode, opts string) (interface{}, []event, error) {
+	s.nextID++
+	entry := Entry{ID: s.nextID, Filename: filename}
+	var start float64
+	for _, opt := range splitOptions(opts) {
+		switch opt[0] {
+		case "force-media-title":
+			entry.Title = opt[1]
+		case "start":
+			start, _ = strconv.ParseFloat(opt[1], 64)
+		}
+	}
+	reply := map[string]interface{}{"playlist_entry_id": entry.ID}
+
+	switch mode {
+	case "replace":
+		events := s.end("stop", "")
+		s.playlist = []Entry{entry}
+		return reply, append(events, s.start(0, start)...), nil
+	case "append", "append-play":
+		s.playlist = append(s.playlist, entry)
+		if mode == "append-play" && s.current == -1 {
+			return reply, s.start(len(s.playlist)-1, start), nil
+		}
+		return reply, nil, nil
+	}
+	s.nextID--
+	return nil, nil, fmt.Errorf("invalid parameter")
+}
+
+// af edits the audio filter chain. Filters are matched by their @label if
+// they have one. s.mu must be locked.
+func (s *Server) af(op, filter string) error {
+	index := -1
+	for i, f := range s.filters {
+		if f == filter || (filterLabel(f) != "" && filterLabel(f) == filterLabel(filter)) {
+			index = i
+		}
+	}
+
+	switch op {
+	case "add":
+		if index != -1 {
+			s.filters[index] = filter
+		} else {
+			s.filters = append(s.filters, filter)
+		}
+	case "remove":
+		if index == -1 {
+			return fmt.Errorf("error running command")
+		}
+		s.filters = append(s.filters[:index], s.filters[index+1:]...)
+	case "set":
+		s.filters = nil
+		if filter != "" {
+			s.filters = strings.Split(filter, ",")
+		}
+	case "clr":
+		s.filters = nil
+	default:
+		return fmt.Errorf("invalid parameter")
+	}
+	return nil
+}
+
+// afCommand records a command sent to a labelled filter. mpv's label
+// argument has no @. s.mu must be locked.
+func (s *Server) afCommand(label, cmd, arg string) error {
+	label = "@" + strings.TrimPrefix(label, "@")
+	for _, f := range s.filters {
+		if filterLabel(f) == label {
+			s.filterCommands[label+" "+cmd] = arg
+			return nil
+		}
+	}
+	return fmt.Errorf("error running command")
+}
+
+// filterLabel returns the @label of a filter, or "". A bare "@label" names
+// the labelled filter, as in af remove.
+func filterLabel(filter string) string {
+	if label, _, _ := strings.Cut(filter, ":"); strings.HasPrefix(label, "@") {
+		return label
+	}
+	return ""
+}
+
+// splitOptions parses loadfile's name=value list, including mpv's
+// %length% quoting
+func splitOptions(opts string) [][2]string {
+	var out [][2]string
+	for opts != "" {
+		name, rest, ok := strings.Cut(opts, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, "%") {
+			end := strings.Index(rest[1:], "%")
+			if end == -1 {
+				break
+			}
+			n, err := strconv.Atoi(rest[1 : end+1])
+			if err != nil || end+2+n > len(rest) {
+				break
+			}
+			value = rest[end+2 : end+2+n]
+			rest = rest[end+2+n:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+		out = append(out, [2]string{name, value})
+		opts = strings.TrimPrefix(rest, ",")
+	}
+	return out
+}
+
+// start plays playlist[index]. s.mu must be locked.
+func (s *Server) start(index int, pos float64) []event {
+	s.current = index
+	entry := s.playlist[index]
+	title := entry.Title
+	if title == "" {
+		title = filepath.Base(entry.Filename)
+	}
+
+	events := []event{{"event": "start-file", "playlist_entry_id": entry.ID}}
+	events = append(events, s.set("idle-active", false)...)
+	events = append(events, s.set("path", entry.Filename)...)
+	events = append(events, s.set("media-title", title)...)
+	events = append(events, s.set("time-pos", pos)...)
+	return events
+}
+
+// end finishes the current file, if any. s.mu must be locked.
+func (s *Server) end(reason, fileError string) []event {
+	if s.current == -1 {
+		return nil
+	}
+	ev := event{"event": "end-file", "reason": reason, "playlist_entry_id": s.playlist[s.current].ID}
+	if fileError != "" {
+		ev["file_error"] = fileError
+	}
+	s.current = -1
+	return []event{ev}
+}
+
+// stop ends the current file and goes idle. s.mu must be locked.
+func (s *Server) stop() []event {
+	events := s.end("stop", "")
+	return append(events, s.idle()...)
+}
+
+// idle clears the per-file properties. s.mu must be locked.
+func (s *Server) idle() []event {
+	var events []event
+	for _, prop := range []string{"path", "media-title", "time-pos", "duration"} {
+		events = append(events, s.set(prop, nil)...)
+	}
+	return append(events, s.set("idle-active", true)...)
+}
+
+// set changes a property, nil makes it unavailable. s.mu must be locked.
+func (s *Server) set(prop string, val interface{}) []event {
+	if val == nil {
+		delete(s.props, prop)
+	} else {
+		s.props[prop] = val
+	}
+	return []event{s.propertyEvent(prop)}
+}
+
+func (s *Server) propertyEvent(prop string) event {
+	return event{"event": "property-change", "name": prop, "data": s.props[prop]}
+}
+
+func (s *Server) playlistProperty() []interface{} {
+	list := make([]interface{}, len(s.playlist))
+	for i, e := range s.playlist {
+		item := map[string]interface{}{"id": e.ID, "filename": e.Filename}
+		if e.Title != "" {
+			item["title"] = e.Title
+		}
+		if i == s.current {
+			item["current"] = true
+			item["playing"] = true
+		}
+		list[i] = item
+	}
+	return list
+}
+
+// broadcast sends events to every client, property changes only where observed
+func (s *Server) broadcast(events []event) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		for _, ev := range events {
+			if ev["event"] == "property-change" {
+				s.mu.Lock()
+				id, ok := c.observed[ev["name"].(string)]
+				s.mu.Unlock()
+				if !ok {
+					continue
+				}
+				ev = event{"event": "property-change", "id": id, "name": ev["name"], "data": ev["data"]}
+			}
+			c.send(ev)
+		}
+	}
+}
+
+func (c *client) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.Write(append(data, '\n'))
+}
+
+// Finish ends the current file as if it played to the end. Like mpv, it moves
+// on to the next playlist entry if there is one, or goes idle.
+func (s *Server) Finish() {
+	s.finish("eof", "")
+}
+
+// Fail ends the current file with an error, e.g. "unrecognized file format"
+func (s *Server) Fail(fileError string) {
+	s.finish("error", fileError)
+}
+
+func (s *Server) finish(reason, fileError string) {
+	s.mu.Lock()
+	next := s.current + 1
+	events := s.end(reason, fileError)
+	if events != nil && next < len(s.playlist) {
+		events = append(events, s.start(next, 0)...)
+	} else {
+		events = append(events, s.idle()...)
+	}
+	s.mu.Unlock()
+	s.broadcast(events)
+}
+
+// SetProperty changes a property as if mpv changed it, e.g. time-pos
+func (s *Server) SetProperty(prop string, val interface{}) {
+	s.mu.Lock()
+	events := s.set(prop, val)
+	s.mu.Unlock()
+	s.broadcast(events)
+}
+
+// Property returns a property's current value
+func (s *Server) Property(prop string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.props[prop]
+	return val, ok
+}
+
+// Current returns the playing entry
+func (s *Server) Current() (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == -1 {
+		return Entry{}, false
+	}
+	return s.playlist[s.current], true
+}
+
+// Playlist returns the fake's playlist
+func (s *Server) Playlist() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry(nil), s.playlist...)
+}
+
+// Filters returns the audio filter chain
+func (s *Server) Filters() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.filters...)
+}
+
+// FilterCommand returns the last argument af-command sent to the filter
+// labelled label with command cmd
+func (s *Server) FilterCommand(label, cmd string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arg, ok := s.filterCommands["@"+strings.TrimPrefix(label, "@")+" "+cmd]
+	return arg, ok
+}
+
+// Commands returns every command received so far, in order
+func (s *Server) Commands() [][]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]interface{}(nil), s.commands...)
+}
@@ -1,17 +1,29 @@
 package player
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// observedProperties are kept up to date through property-change events
+// so status reads don't need an IPC round-trip.
+var observedProperties = []string{
+	"pause",
+	"volume",
+	"duration",
+	"media-title",
+	"path",
+	"idle-active",
+}
+
 // Player controls the MPV process
 type Player struct {
 	socketPath   string
@@ -19,6 +31,27 @@ type Player struct {
 	cmd          *exec.Cmd
 	mutex        sync.Mutex
 	currentTitle string // Simple status tracking
+
+	// Supervision, guarded by mutex
+	stopped   bool      // Set by Stop, mpv exiting is expected from then on
+	running   bool      // mpv is up and connected
+	startedAt time.Time // When the running mpv was launched
+	crashes   int
+	restarts  int
+	lastCrash time.Time
+
+	level level // Gain and fades, see level.go; guarded by mutex
+
+	connMu    sync.Mutex
+	conn      *ipcConn
+	nextReqID atomic.Int64
+
+	subMu       sync.Mutex
+	subscribers map[int]*subscriber
+	nextSubID   int
+
+	propsMu sync.RWMutex
+	props   map[string]interface{}
 }
 
 // New creates a new Player instance
@@ -37,14 +70,30 @@ func New(ytDlpPath string) *Player {
 		socketPath:   socketPath,
 		ytDlpPath:    ytDlpPath,
 		currentTitle: "Idle",
+		subscribers:  make(map[int]*subscriber),
+		props:        make(map[string]interface{}),
+	}
+}
+
+// Attach returns a Player for an mpv that is already listening on socketPath,
+// such as one started by hand with --input-ipc-server or a fake from mpvtest.
+// The player doesn't own that process: Stop only disconnects, and nothing
+// restarts it.
+func Attach(socketPath string) (*Player, error) {
+	p := New("")
+	p.socketPath = socketPath
+	if _, err := p.connection(); err != nil {
+		return nil, fmt.Errorf("failed to connect to mpv socket: %w", err)
 	}
+	p.running = true
+	return p, nil
 }
 
 // GetStatus returns the locally tracked status.
 // It also attempts to fetch the current media title from mpv if possible.
 func (p *Player) GetStatus() string {
 	// Try to get actual media title from MPV
-	if title, err := p.GetProperty("media-title"); err == nil {
+	if title, ok := p.Observed("media-title"); ok {
 		if titleStr, ok := title.(string); ok && titleStr != "" {
 			p.mutex.Lock()
 			p.currentTitle = titleStr
@@ -58,11 +107,24 @@ func (p *Player) GetStatus() string {
 	return p.currentTitle
 }
 
-// Start launches the mpv process in idle mode
+// Start launches the mpv process in idle mode and keeps it running: if mpv
+// exits on its own it is started again, see supervise.go.
 func (p *Player) Start() error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	p.stopped = false
+	exited, err := p.launch()
+	if err != nil {
+		return err
+	}
+	go p.supervise(exited)
+	return nil
+}
+
+// launch starts mpv and connects to its IPC socket. The returned channel
+// receives the result of cmd.Wait once mpv exits. p.mutex must be locked.
+func (p *Player) launch() (<-chan error, error) {
 	// Check if socket exists and remove it (cleanup from previous runs)
 	if runtime.GOOS != "windows" {
 		if _, err := os.Stat(p.socketPath); err == nil {
@@ -74,22 +136,34 @@ func (p *Player) Start() error {
 	// --vo=null discards video output but keeps video stream active (fixes stream selection)
 	// --input-ipc-server allows us to control it
 	// --ytdl-format=bestaudio/best ensures we get audio
+	// --prefetch-playlist and --gapless-audio let a preloaded next file follow
+	// the current one without a gap
 	args := []string{
 		"--idle",
 		"--vo=null",
 		"--ytdl-format=bestaudio/best",
+		"--prefetch-playlist=yes",
+		"--gapless-audio=weak",
 		"--input-ipc-server=" + p.socketPath,
 		"--script-opts=ytdl_hook-ytdl_path=" + p.ytDlpPath,
 	}
 
-	p.cmd = exec.Command("mpv", args...)
-	p.cmd.Stdout = os.Stdout
-	p.cmd.Stderr = os.Stderr
+	cmd := exec.Command("mpv", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 
 	// Start in background
-	if err := p.cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start mpv: %w", err)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mpv: %w", err)
 	}
+	p.cmd = cmd
+	p.startedAt = time.Now()
+	p.level.applied = "" // A fresh mpv has no filters
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
 
 	// Wait for socket to appear
 	socketFound := false
@@ -100,128 +174,325 @@ func (p *Player) Start() error {
 			break
 		}
 		// Check if process died
-		if p.cmd.ProcessState != nil && p.cmd.ProcessState.Exited() {
-			return fmt.Errorf("mpv process exited unexpectedly")
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("mpv process exited unexpectedly: %v", err)
+		default:
 		}
 	}
 
 	if !socketFound {
-		return fmt.Errorf("timed out waiting for mpv socket at %s", p.socketPath)
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("timed out waiting for mpv socket at %s", p.socketPath)
+	}
+
+	if _, err := p.connection(); err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("failed to connect to mpv socket: %w", err)
 	}
 
+	p.running = true
 	log.Println("MPV started successfully")
-	return nil
+	return exited, nil
 }
 
-// Stop kills the mpv process
+// Stop kills the mpv process for good
 func (p *Player) Stop() {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
+
+	p.stopped = true
+	p.closeConnection(fmt.Errorf("player stopped"))
+
 	if p.cmd != nil && p.cmd.Process != nil {
 		p.cmd.Process.Kill()
 	}
 }
 
-// sendCommand sends a JSON IPC command to mpv
-func (p *Player) sendCommand(command []interface{}) error {
-	// Format: { "command": ["cmd", "arg1", ...] }
-	payload := map[string]interface{}{
-		"command": command,
+// closeConnection fails outstanding requests on the IPC connection and drops it
+func (p *Player) closeConnection(err error) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+	if p.conn != nil {
+		p.conn.shutdown(err)
+		p.conn = nil
 	}
+}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+// connection returns the live IPC connection, dialing a new one if needed.
+// Every new connection re-registers the observed properties.
+func (p *Player) connection() (*ipcConn, error) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.conn != nil && !p.conn.isClosed() {
+		return p.conn, nil
 	}
-	// Append newline as per line-based protocol
-	data = append(data, '\n')
 
-	var conn net.Conn
+	if runtime.GOOS == "windows" {
+		// Windows named pipes handling would be needed here,
+		// usually via "github.com/Microsoft/go-winio" but keeping it simple for now as target is Linux
+		return nil, fmt.Errorf("windows named pipe support not fully implemented in this snippet")
+	}
 
+	var conn *ipcConn
+	var err error
 	// Retry connection logic
 	for i := 0; i < 3; i++ {
-		if runtime.GOOS == "windows" {
-			// Windows named pipes handling would be needed here,
-			// usually via "github.com/Microsoft/go-winio" but keeping it simple for now as target is Linux
-			return fmt.Errorf("windows named pipe support not fully implemented in this snippet")
-		} else {
-			conn, err = net.Dial("unix", p.socketPath)
-		}
-
+		conn, err = dialIPC(p.socketPath, p.dispatch)
 		if err == nil {
 			break
 		}
 		time.Sleep(200 * time.Millisecond)
 	}
-
 	if err != nil {
-		return fmt.Errorf("failed to connect to mpv socket: %w", err)
+		return nil, err
+	}
+
+	p.propsMu.Lock()
+	p.props = make(map[string]interface{})
+	p.propsMu.Unlock()
+
+	for i, prop := range observedProperties {
+		if _, err := conn.request(p.newRequestID(), []interface{}{"observe_property", i + 1, prop}); err != nil {
+			log.Printf("Failed to observe mpv property %s: %v", prop, err)
+		}
+	}
+
+	p.conn = conn
+	return conn, nil
+}
+
+// newRequestID returns a monotonically increasing IPC request id.
+// It stays far below 2^53 so it survives the float64 round-trip in JSON.
+func (p *Player) newRequestID() int {
+	return int(p.nextReqID.Add(1))
+}
+
+// dispatch records observed property values and fans the event out to subscribers.
+// It runs on the IPC reader goroutine, so it must never block.
+func (p *Player) dispatch(ev Event) {
+	if ev.Type == EventPropertyChange {
+		p.propsMu.Lock()
+		if ev.Data == nil {
+			delete(p.props, ev.Property)
+		} else {
+			p.props[ev.Property] = ev.Data
+		}
+		p.propsMu.Unlock()
+	}
+
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+	for _, sub := range p.subscribers {
+		sub.push(ev)
+	}
+}
+
+// Subscribe returns a channel receiving every mpv event from now on
+// and a function that unsubscribes and closes the channel.
+// A subscriber that falls behind loses the oldest property changes, which
+// Observed still has the latest of, but never file or restart events.
+func (p *Player) Subscribe() (<-chan Event, func()) {
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+
+	id := p.nextSubID
+	p.nextSubID++
+	sub := &subscriber{
+		wake: make(chan struct{}, 1),
+		out:  make(chan Event),
+		done: make(chan struct{}),
+	}
+	p.subscribers[id] = sub
+	go sub.deliver()
+
+	var once sync.Once
+	return sub.out, func() {
+		once.Do(func() {
+			p.subMu.Lock()
+			delete(p.subscribers, id)
+			p.subMu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// maxQueuedEvents is how many events a subscriber may fall behind before
+// property changes are dropped
+const maxQueuedEvents = 64
+
+// subscriber queues events for one Subscribe channel
+type subscriber struct {
+	mu    sync.Mutex
+	queue []Event
+	wake  chan struct{}
+	out   chan Event
+	done  chan struct{} // Closed on unsubscribe
+}
+
+// push queues an event without blocking. When the queue is full the oldest
+// property change makes room; file events drive playback and are always kept.
+func (s *subscriber) push(ev Event) {
+	s.mu.Lock()
+	if len(s.queue) >= maxQueuedEvents {
+		if i := slices.IndexFunc(s.queue, func(e Event) bool { return e.Type == EventPropertyChange }); i != -1 {
+			log.Printf("MPV event subscriber is full, dropping %s event", s.queue[i].Type)
+			s.queue = slices.Delete(s.queue, i, i+1)
+		} else if ev.Type == EventPropertyChange {
+			log.Printf("MPV event subscriber is full, dropping %s event", ev.Type)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.queue = append(s.queue, ev)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver hands queued events to the subscriber in order until it
+// unsubscribes, then closes its channel
+func (s *subscriber) deliver() {
+	defer close(s.out)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			ev := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			select {
+			case s.out <- ev:
+			case <-s.done:
+				return
+			}
+		}
 	}
-	defer conn.Close()
+}
+
+// Observed returns the last value mpv reported for an observed property.
+// The second result is false if the property is not observed or currently unavailable.
+func (p *Player) Observed(prop string) (interface{}, bool) {
+	p.propsMu.RLock()
+	defer p.propsMu.RUnlock()
+	val, ok := p.props[prop]
+	return val, ok
+}
 
-	_, err = conn.Write(data)
+// sendCommand sends a JSON IPC command to mpv and waits for it to be acknowledged
+func (p *Player) sendCommand(command []interface{}) error {
+	_, err := p.sendRequest(command)
 	return err
 }
 
-// Play loads and plays a URL by appending it and then playing it.
-// It checks if the item is already in the playlist to avoid duplication.
+// Play replaces whatever is loaded with url and plays it from the start
 func (p *Player) Play(url string, title string) error {
+	_, err := p.Load(url, title, 0)
+	return err
+}
+
+// Load replaces whatever mpv has loaded with url, starting at start seconds,
+// and returns the mpv playlist entry id mpv reports in start-file/end-file events.
+// mpv only ever holds this one file, and at most one preloaded to follow it;
+// the play order belongs to the caller.
+func (p *Player) Load(url string, title string, start float64) (int, error) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
 	p.currentTitle = title
 
-	// Check if already in playlist
-	playlist, err := p.GetPlaylist()
-	if err == nil {
-		for i, item := range playlist {
-			if filename, ok := item["filename"].(string); ok && filename == url {
-				// Found it, play this index
-				return p.PlayIndex(i)
-			}
-		}
+	var opts []string
+	if title != "" {
+		opts = append(opts, fileOption("force-media-title", title))
+	}
+	if start > 0 {
+		opts = append(opts, fileOption("start", fmt.Sprintf("%.3f", start)))
 	}
+	return p.loadfile(url, "replace", opts)
+}
 
-	// First append
-	if err := p.append(url, title); err != nil {
-		return err
+// Preload queues url in mpv to play when the current file ends, replacing
+// any file preloaded before, and returns its playlist entry id. mpv opens it
+// ahead of time, so it follows without a gap.
+func (p *Player) Preload(url string, title string) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Drops everything but the current file: the last preload and the files
+	// already played through
+	if err := p.sendCommand([]interface{}{"playlist-clear"}); err != nil {
+		return 0, err
 	}
-	
-	// Then get playlist size to know the index of the last item
-	playlist, err = p.GetPlaylist()
+	var opts []string
+	if title != "" {
+		opts = append(opts, fileOption("force-media-title", title))
+	}
+	return p.loadfile(url, "append", opts)
+}
+
+// ClearPreload removes the preloaded file, so mpv goes idle when the current
+// one ends
+func (p *Player) ClearPreload() error {
+	return p.sendCommand([]interface{}{"playlist-clear"})
+}
+
+// loadfile sends loadfile and returns the id of the new playlist entry.
+// p.mutex must be locked.
+func (p *Player) loadfile(url, mode string, opts []string) (int, error) {
+	command := []interface{}{"loadfile", url, mode}
+	if len(opts) > 0 {
+		command = append(command, strings.Join(opts, ","))
+	}
+	data, err := p.sendRequest(command)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if len(playlist) == 0 {
-		return fmt.Errorf("playlist empty after append")
+
+	// mpv reports the new entry id in the loadfile reply; older versions
+	// don't, in which case the id is read back from the playlist.
+	if reply, ok := data.(map[string]interface{}); ok {
+		if id, ok := reply["playlist_entry_id"].(float64); ok {
+			return int(id), nil
+		}
 	}
-	
-	// Play the last item (0-based index)
-	index := len(playlist) - 1
-	return p.PlayIndex(index)
+	playlist, err := p.GetPlaylist()
+	if err != nil || len(playlist) == 0 {
+		return 0, nil
+	}
+	return entryID(playlist[len(playlist)-1]), nil
 }
 
-// PlayIndex plays the item at the specific playlist index
-func (p *Player) PlayIndex(index int) error {
-	return p.sendCommand([]interface{}{"playlist-play-index", index})
+// StopPlayback unloads the current file and leaves mpv idle
+func (p *Player) StopPlayback() error {
+	return p.sendCommand([]interface{}{"stop"})
 }
 
-// Append adds a URL to the internal playlist
-func (p *Player) Append(url string, title string) error {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	return p.append(url, title)
+// fileOption formats a per-file loadfile option. The value uses mpv's
+// %length% quoting so commas in titles don't split the option list.
+func fileOption(name, value string) string {
+	return fmt.Sprintf("%s=%%%d%%%s", name, len(value), value)
 }
 
-// append is the internal implementation without locking
-func (p *Player) append(url string, title string) error {
-	var err error
-	if title != "" {
-		_, err = p.sendRequest([]interface{}{"loadfile", url, "append", fmt.Sprintf("force-media-title=%s", title)})
-	} else {
-		_, err = p.sendRequest([]interface{}{"loadfile", url, "append"})
+// entryID reads the unique id mpv assigns to a playlist entry
+func entryID(item map[string]interface{}) int {
+	if id, ok := item["id"].(float64); ok {
+		return int(id)
 	}
-	return err
+	return 0
 }
 
 // Pause toggles pause
@@ -230,14 +501,9 @@ func (p *Player) Pause() error {
 	return p.sendCommand([]interface{}{"cycle", "pause"})
 }
 
-// Next skips to next track
-func (p *Player) Next() error {
-	return p.sendCommand([]interface{}{"playlist-next"})
-}
-
-// Prev skips to previous track
-func (p *Player) Prev() error {
-	return p.sendCommand([]interface{}{"playlist-prev"})
+// SetPaused pauses or unpauses playback
+func (p *Player) SetPaused(paused bool) error {
+	return p.sendCommand([]interface{}{"set_property", "pause", paused})
 }
 
 // Seek seeks to a position in seconds
@@ -262,54 +528,21 @@ func (p *Player) GetVolume() (float64, error) {
 	return 0, fmt.Errorf("unexpected volume type")
 }
 
-// sendRequest sends a command and waits for a response
+// sendRequest sends a command over the shared connection and waits for its response
 func (p *Player) sendRequest(command []interface{}) (interface{}, error) {
-	// Use a small random request ID to avoid float64 precision issues in JSON
-	// UnixNano is too large for float64 exact representation
-	reqID := int(time.Now().Unix() % 1000000)
-	payload := map[string]interface{}{
-		"command":    command,
-		"request_id": reqID,
-	}
-
-	data, err := json.Marshal(payload)
+	conn, err := p.connection()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to connect to mpv socket: %w", err)
 	}
-	data = append(data, '\n')
 
-	conn, err := net.Dial("unix", p.socketPath)
+	data, err := conn.request(p.newRequestID(), command)
 	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	if _, err := conn.Write(data); err != nil {
-		return nil, err
-	}
-
-	// Read response
-	decoder := json.NewDecoder(conn)
-	for {
-		var resp map[string]interface{}
-		if err := decoder.Decode(&resp); err != nil {
-			log.Printf("MPV IPC Decode Error: %v", err)
-			return nil, err
-		}
-
-		if id, ok := resp["request_id"]; ok {
-			if idFloat, ok := id.(float64); ok && int(idFloat) == reqID {
-				if errVal, ok := resp["error"]; ok && errVal != "success" {
-					if errVal != "property unavailable" {
-						log.Printf("MPV IPC Error: %v", errVal)
-					}
-					return nil, fmt.Errorf("mpv error: %v", errVal)
-				}
-				// log.Printf("MPV Response for %v: %v", command, resp["data"])
-				return resp["data"], nil
-			}
+		if err.Error() != "mpv error: property unavailable" {
+			log.Printf("MPV IPC Error: %v", err)
 		}
+		return nil, err
 	}
+	return data, nil
 }
 
 // GetProperty fetches a property from mpv
@@ -0,0 +1,352 @@
+package player
+
+import (
+	"kaboomer/internal/player/mpvtest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func attach(t *testing.T) (*Player, *mpvtest.Server) {
+	t.Helper()
+	fake := mpvtest.New(t)
+	p, err := Attach(fake.SocketPath)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, fake
+}
+
+// eventually fails the test if cond doesn't hold within a couple of seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// nextEvent returns the next event of the given type, skipping the others
+func nextEvent(t *testing.T, events <-chan Event, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event", typ)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	p, fake := attach(t)
+
+	tests := []struct {
+		url, title string
+		start      float64
+	}{
+		{"/music/a.m4a", "Plain title", 0},
+		{"/music/b.m4a", "Commas, and = signs", 0},
+		{"/music/c.m4a", "", 42.5},
+	}
+	for i, tt := range tests {
+		id, err := p.Load(tt.url, tt.title, tt.start)
+		if err != nil {
+			t.Fatalf("Load(%q): %v", tt.url, err)
+		}
+		if id != i+1 {
+			t.Errorf("Load(%q) entry id = %d, want %d", tt.url, id, i+1)
+		}
+
+		cur, ok := fake.Current()
+		if !ok || cur.Filename != tt.url || cur.Title != tt.title {
+			t.Errorf("mpv is playing %+v, want %q titled %q", cur, tt.url, tt.title)
+		}
+		if pos, _ := fake.Property("time-pos"); pos != tt.start {
+			t.Errorf("start position = %v, want %v", pos, tt.start)
+		}
+		// loadfile replace leaves only the new file
+		if n := len(fake.Playlist()); n != 1 {
+			t.Errorf("playlist has %d entries, want 1", n)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	p, fake := attach(t)
+	events, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	first, err := p.Load("/music/a.m4a", "A", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events, EventStartFile); ev.PlaylistEntryID != first {
+		t.Errorf("start-file entry = %d, want %d", ev.PlaylistEntryID, first)
+	}
+
+	// Replacing the file stops the old one
+	second, err := p.Load("/music/b.m4a", "B", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := nextEvent(t, events, EventEndFile)
+	if ev.PlaylistEntryID != first || ev.Reason != EndReasonStop {
+		t.Errorf("end-file = %+v, want entry %d with reason stop", ev, first)
+	}
+	nextEvent(t, events, EventStartFile)
+
+	fake.Fail("unrecognized file format")
+	ev = nextEvent(t, events, EventEndFile)
+	if ev.PlaylistEntryID != second || ev.Reason != EndReasonError || ev.FileError != "unrecognized file format" {
+		t.Errorf("end-file = %+v, want entry %d failing", ev, second)
+	}
+}
+
+func TestSlowSubscriberKeepsFileEvents(t *testing.T) {
+	p, _ := attach(t)
+	events, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	// A burst of property changes around an end-file nobody reads yet
+	for i := range 3 * maxQueuedEvents {
+		if i == maxQueuedEvents {
+			p.dispatch(Event{Type: EventEndFile, Reason: EndReasonEOF})
+		}
+		p.dispatch(Event{Type: EventPropertyChange, Property: "volume", Data: float64(i)})
+	}
+
+	// Up to the newest change, older ones may have made room for it
+	newest := float64(3*maxQueuedEvents - 1)
+	var ends int
+	for done := false; !done; {
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case EventEndFile:
+				ends++
+			case EventPropertyChange:
+				done = ev.Data == newest
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the newest property change")
+		}
+	}
+	if ends != 1 {
+		t.Errorf("got %d end-file events, want 1", ends)
+	}
+}
+
+func TestObservedProperties(t *testing.T) {
+	p, fake := attach(t)
+
+	if err := p.SetVolume(40); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "volume 40", func() bool {
+		v, ok := p.Observed("volume")
+		return ok && v == 40.0
+	})
+
+	if err := p.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "pause", func() bool {
+		v, _ := p.Observed("pause")
+		return v == true
+	})
+
+	if _, err := p.Load("/music/a.m4a", "A", 0); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "media-title", func() bool {
+		v, _ := p.Observed("media-title")
+		return v == "A"
+	})
+	if got := p.GetStatus(); got != "A" {
+		t.Errorf("GetStatus() = %q, want A", got)
+	}
+
+	// Changes mpv makes on its own arrive too
+	fake.SetProperty("duration", 180.0)
+	eventually(t, "duration", func() bool {
+		v, _ := p.Observed("duration")
+		return v == 180.0
+	})
+
+	if err := p.StopPlayback(); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "media-title to go", func() bool {
+		_, ok := p.Observed("media-title")
+		return !ok
+	})
+}
+
+func TestGetPlaylist(t *testing.T) {
+	p, _ := attach(t)
+
+	id, err := p.Load("/music/a.m4a", "A", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist, err := p.GetPlaylist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playlist) != 1 || entryID(playlist[0]) != id || playlist[0]["filename"] != "/music/a.m4a" {
+		t.Errorf("GetPlaylist() = %v", playlist)
+	}
+
+	if _, err := p.GetProperty("no-such-property"); err == nil {
+		t.Error("GetProperty of an unknown property succeeded")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	p, fake := attach(t)
+
+	fake.Disconnect()
+	eventually(t, "the connection to drop", func() bool {
+		p.connMu.Lock()
+		defer p.connMu.Unlock()
+		return p.conn == nil || p.conn.isClosed()
+	})
+
+	// The next request dials again and observes the properties again
+	if err := p.SetVolume(70); err != nil {
+		t.Fatalf("SetVolume after disconnect: %v", err)
+	}
+	eventually(t, "volume 70", func() bool {
+		v, _ := p.Observed("volume")
+		return v == 70.0
+	})
+}
+
+func TestAttachWithoutMpv(t *testing.T) {
+	if _, err := Attach(t.TempDir() + "/missing.sock"); err == nil {
+		t.Error("Attach to a missing socket succeeded")
+	}
+}
+
+func TestPlaylistPlayIndex(t *testing.T) {
+	p, fake := attach(t)
+	events, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	for _, url := range []string{"/music/a.m4a", "/music/b.m4a"} {
+		if err := p.sendCommand([]interface{}{"loadfile", url, "append"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := fake.Current(); ok {
+		t.Fatal("append started playback")
+	}
+
+	if err := p.sendCommand([]interface{}{"playlist-play-index", 1}); err != nil {
+		t.Fatal(err)
+	}
+	ev := nextEvent(t, events, EventStartFile)
+	if cur, _ := fake.Current(); cur.Filename != "/music/b.m4a" || ev.PlaylistEntryID != cur.ID {
+		t.Errorf("playing %+v after start-file for entry %d, want b.m4a", cur, ev.PlaylistEntryID)
+	}
+
+	if err := p.sendCommand([]interface{}{"playlist-play-index", 5}); err == nil {
+		t.Error("playlist-play-index past the end succeeded")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	p, fake := attach(t)
+
+	// Nothing to do at 0 dB, the filter isn't added until needed
+	if err := p.SetGain(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Filters(); len(got) != 0 {
+		t.Errorf("filters = %q, want none", got)
+	}
+
+	steps := []struct {
+		name string
+		set  func() error
+		want string
+	}{
+		{"gain", func() error { return p.SetGain(-6.5) }, "0.4732"},
+		{"rounded gain", func() error { return p.SetGain(3.004) }, "1.4125"},
+		{"fade in", func() error { return p.SetFade(2, 0) }, "1.4125*if(isnan(t),1,clip(t/2.000,0,1))"},
+		{"fade out", func() error { return p.SetFade(2, 180.5) }, "1.4125*if(isnan(t),1,clip(min(t/2.000,(180.500-t)/2.000),0,1))"},
+		{"no fades", func() error { return p.SetFade(0, 180.5) }, "1.4125"},
+		{"unity", func() error { return p.SetGain(0) }, "1.0000"},
+	}
+	for _, step := range steps {
+		if err := step.set(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got, _ := fake.FilterCommand("kaboomer-level", "volume"); got != step.want {
+			t.Errorf("%s: volume = %q, want %q", step.name, got, step.want)
+		}
+	}
+
+	// Changes go through af-command, the chain itself is built once
+	want := []string{"@kaboomer-level:lavfi-volume=volume=1:eval=frame"}
+	if got := fake.Filters(); !slices.Equal(got, want) {
+		t.Errorf("filters = %q, want %q", got, want)
+	}
+	var adds int
+	for _, cmd := range fake.Commands() {
+		if cmd[0] == "af" {
+			adds++
+		}
+	}
+	if adds != 1 {
+		t.Errorf("sent af %d times, want 1", adds)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	p, fake := attach(t)
+	events, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	if _, err := p.Load("/music/a.m4a", "A", 0); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, EventStartFile)
+	if _, err := p.Preload("/music/b.m4a", "B"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := p.Preload("/music/c.m4a", "C")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second preload replaced the first
+	var files []string
+	for _, e := range fake.Playlist() {
+		files = append(files, e.Filename)
+	}
+	if want := []string{"/music/a.m4a", "/music/c.m4a"}; !slices.Equal(files, want) {
+		t.Fatalf("playlist = %q, want %q", files, want)
+	}
+
+	fake.Finish()
+	if ev := nextEvent(t, events, EventStartFile); ev.PlaylistEntryID != id {
+		t.Errorf("started entry %d, want the preloaded %d", ev.PlaylistEntryID, id)
+	}
+
+	if err := p.ClearPreload(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Playlist()); n != 1 {
+		t.Errorf("playlist has %d entries after ClearPreload, want 1", n)
+	}
+}
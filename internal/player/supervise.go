@@ -0,0 +1,92 @@
+package player
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// EventRestart is not an mpv event: the player sends it to subscribers after
+// mpv died and a fresh instance is up. Nothing is loaded in it yet, and no
+// end-file arrives for the file that was playing.
+const EventRestart EventType = "kaboomer-restart"
+
+const (
+	// Restarts back off from restartMinDelay to restartMaxDelay while mpv keeps
+	// dying, so a broken audio device doesn't turn into a busy loop
+	restartMinDelay = time.Second
+	restartMaxDelay = 30 * time.Second
+	// stableRun is how long mpv must have run for a crash to reset the backoff
+	stableRun = time.Minute
+)
+
+// Health describes the mpv process for the status API
+type Health struct {
+	Running   bool       `json:"running"`
+	Crashes   int        `json:"crashes"`  // Unexpected mpv exits
+	Restarts  int        `json:"restarts"` // Successful relaunches after a crash
+	LastCrash *time.Time `json:"last_crash,omitempty"`
+}
+
+// Health reports whether mpv is up and how often it had to be restarted
+func (p *Player) Health() Health {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	h := Health{
+		Running:  p.running && !p.stopped,
+		Crashes:  p.crashes,
+		Restarts: p.restarts,
+	}
+	if !p.lastCrash.IsZero() {
+		crash := p.lastCrash
+		h.LastCrash = &crash
+	}
+	return h
+}
+
+// supervise waits for mpv to exit and, unless Stop was called, starts it
+// again and tells subscribers with an EventRestart
+func (p *Player) supervise(exited <-chan error) {
+	delay := restartMinDelay
+	for {
+		err := <-exited
+
+		p.mutex.Lock()
+		p.running = false
+		if p.stopped {
+			p.mutex.Unlock()
+			return
+		}
+		p.crashes++
+		p.lastCrash = time.Now()
+		if time.Since(p.startedAt) >= stableRun {
+			delay = restartMinDelay
+		}
+		p.mutex.Unlock()
+
+		log.Printf("MPV exited unexpectedly (%v), restarting in %s", err, delay)
+		p.closeConnection(fmt.Errorf("mpv exited: %v", err))
+
+		for {
+			time.Sleep(delay)
+			delay = min(delay*2, restartMaxDelay)
+
+			p.mutex.Lock()
+			if p.stopped {
+				p.mutex.Unlock()
+				return
+			}
+			exited, err = p.launch()
+			if err == nil {
+				p.restarts++
+				p.mutex.Unlock()
+				break
+			}
+			p.mutex.Unlock()
+			log.Printf("Failed to restart mpv: %v", err)
+		}
+
+		p.dispatch(Event{Type: EventRestart})
+	}
+}
@@ -0,0 +1,199 @@
+package playlists
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Both formats carry the track id in a field other players ignore, so an
+// exported playlist imports back onto the same cached files.
+const (
+	m3uIDDirective = "#KABOOMER-ID:"
+	xspfIDRel      = "urn:kaboomer:id"
+)
+
+// Import parses an extended M3U or XSPF playlist, telling them apart by content.
+// base resolves relative paths in M3U files, see ParseM3U.
+func Import(data []byte, base string) ([]Entry, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return ParseXSPF(bytes.NewReader(data))
+	}
+	return ParseM3U(bytes.NewReader(data), base)
+}
+
+// ParseM3U reads an M3U playlist. #EXTINF lines supply the duration and
+// "Artist - Title"; plain M3U entries are titled after their location.
+// File paths become file:// URLs, relative ones resolved against the
+// directory base. Without a base, entries with relative paths are skipped.
+func ParseM3U(r io.Reader, base string) ([]Entry, error) {
+	var entries []Entry
+	var pending Entry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>[ attributes],<Artist - Title>
+			info, name, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if fields := strings.Fields(info); len(fields) > 0 {
+				if secs, err := strconv.ParseFloat(fields[0], 64); err == nil && secs > 0 {
+					pending.Duration = int(secs)
+				}
+			}
+			if artist, title, ok := strings.Cut(name, " - "); ok {
+				pending.Artist, pending.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+			} else {
+				pending.Title = strings.TrimSpace(name)
+			}
+		case strings.HasPrefix(line, m3uIDDirective):
+			pending.ID = strings.TrimSpace(strings.TrimPrefix(line, m3uIDDirective))
+		case strings.HasPrefix(line, "#"):
+			// Header and directives we don't use
+		default:
+			if location, ok := m3uLocation(line, base); ok {
+				pending.URL = location
+				if pending.Title == "" {
+					pending.Title = line
+				}
+				entries = append(entries, pending)
+			}
+			pending = Entry{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read M3U: %w", err)
+	}
+	return entries, nil
+}
+
+// m3uLocation turns an M3U location into a URL. ok is false for a relative
+// path without a base to resolve it against.
+func m3uLocation(location, base string) (string, bool) {
+	if u, err := url.Parse(location); err == nil && len(u.Scheme) > 1 {
+		return location, true // One letter schemes are Windows drives
+	}
+
+	path := filepath.FromSlash(location)
+	if !filepath.IsAbs(path) {
+		if base == "" {
+			return "", false
+		}
+		path = filepath.Join(base, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), true
+}
+
+// WriteM3U writes p as extended M3U
+func WriteM3U(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#PLAYLIST:%s\n", p.Name)
+	for _, e := range p.Entries {
+		duration := e.Duration
+		if duration == 0 {
+			duration = -1 // Unknown
+		}
+		name := e.Title
+		if e.Artist != "" {
+			name = e.Artist + " - " + e.Title
+		}
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", duration, name)
+		if e.ID != "" {
+			fmt.Fprintf(bw, "%s%s\n", m3uIDDirective, e.ID)
+		}
+		fmt.Fprintf(bw, "%s\n", e.URL)
+	}
+	return bw.Flush()
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"` // Any namespace, some writers leave it out
+	Xmlns   string      `xml:"xmlns,attr"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string     `xml:"location"`
+	Title    string     `xml:"title,omitempty"`
+	Creator  string     `xml:"creator,omitempty"`
+	Duration int        `xml:"duration,omitempty"` // Milliseconds
+	Meta     []xspfMeta `xml:"meta,omitempty"`
+}
+
+type xspfMeta struct {
+	Rel   string `xml:"rel,attr"`
+	Value string `xml:",chardata"`
+}
+
+// ParseXSPF reads an XSPF playlist. Tracks without a location are skipped.
+func ParseXSPF(r io.Reader) ([]Entry, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse XSPF: %w", err)
+	}
+
+	var entries []Entry
+	for _, t := range doc.Tracks {
+		location := strings.TrimSpace(t.Location)
+		if location == "" {
+			continue
+		}
+		e := Entry{
+			URL:      location,
+			Title:    strings.TrimSpace(t.Title),
+			Artist:   strings.TrimSpace(t.Creator),
+			Duration: t.Duration / 1000,
+		}
+		if e.Title == "" {
+			e.Title = location
+		}
+		for _, m := range t.Meta {
+			if m.Rel == xspfIDRel {
+				e.ID = strings.TrimSpace(m.Value)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// WriteXSPF writes p as XSPF
+func WriteXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{Xmlns: "http://xspf.org/ns/0/", Version: "1", Title: p.Name}
+	for _, e := range p.Entries {
+		t := xspfTrack{
+			Location: e.URL,
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Duration: e.Duration * 1000,
+		}
+		if e.ID != "" {
+			t.Meta = []xspfMeta{{Rel: xspfIDRel, Value: e.ID}}
+		}
+		doc.Tracks = append(doc.Tracks, t)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
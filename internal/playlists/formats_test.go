@@ -0,0 +1,196 @@
+package playlists
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var roundTripEntries = []Entry{
+	{ID: "a1", URL: "https://www.youtube.com/watch?v=a1", Title: "Song", Artist: "Band", Duration: 215},
+	{URL: "https://example.com/stream.mp3", Title: "No artist or length"},
+	{ID: "local-0123456789ab", URL: "file:///music/B%C3%A4nd/Song%20%231.flac", Title: "Ünïcode & <markup>", Artist: "Bänd"},
+}
+
+func TestM3URoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteM3U(&buf, &Playlist{Name: "Mix", Entries: roundTripEntries}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseM3U(&buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, roundTripEntries) {
+		t.Errorf("round trip = %+v, want %+v", got, roundTripEntries)
+	}
+}
+
+func TestXSPFRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteXSPF(&buf, &Playlist{Name: "Mix", Entries: roundTripEntries}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseXSPF(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, roundTripEntries) {
+		t.Errorf("round trip = %+v, want %+v", got, roundTripEntries)
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		base    string
+		want    []Entry
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			data: "https://example.com/a.mp3\r\n\r\nhttps://example.com/b.mp3\r\n",
+			want: []Entry{
+				{URL: "https://example.com/a.mp3", Title: "https://example.com/a.mp3"},
+				{URL: "https://example.com/b.mp3", Title: "https://example.com/b.mp3"},
+			},
+		},
+		{
+			name: "byte order mark and attributes",
+			data: "\ufeff#EXTM3U\n#EXTINF:-1 tvg-id=\"x\",Band - Song\nhttps://example.com/a.mp3\n",
+			want: []Entry{{URL: "https://example.com/a.mp3", Title: "Song", Artist: "Band"}},
+		},
+		{
+			name: "bad duration",
+			data: "#EXTINF:soon,Song\nhttps://example.com/a.mp3\n",
+			want: []Entry{{URL: "https://example.com/a.mp3", Title: "Song"}},
+		},
+		{
+			name: "info without a location",
+			data: "#EXTINF:10,Song\n#KABOOMER-ID:a1\n",
+		},
+		{
+			name: "info applies to the next entry only",
+			data: "#EXTINF:10,Song\n#KABOOMER-ID:a1\nhttps://example.com/a.mp3\nhttps://example.com/b.mp3\n",
+			want: []Entry{
+				{ID: "a1", URL: "https://example.com/a.mp3", Title: "Song", Duration: 10},
+				{URL: "https://example.com/b.mp3", Title: "https://example.com/b.mp3"},
+			},
+		},
+		{
+			name: "paths",
+			data: "/music/Band/Song #1.mp3\nBand/Other.mp3\n../up.mp3\n",
+			base: "/music",
+			want: []Entry{
+				{URL: "file:///music/Band/Song%20%231.mp3", Title: "/music/Band/Song #1.mp3"},
+				{URL: "file:///music/Band/Other.mp3", Title: "Band/Other.mp3"},
+				{URL: "file:///up.mp3", Title: "../up.mp3"},
+			},
+		},
+		{
+			name: "relative paths without a base",
+			data: "Band/Other.mp3\n/music/a.mp3\n",
+			want: []Entry{{URL: "file:///music/a.mp3", Title: "/music/a.mp3"}},
+		},
+		{
+			name:    "line too long",
+			data:    "https://example.com/" + strings.Repeat("a", 70000) + "\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseM3U(strings.NewReader(tt.data), tt.base)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseM3U() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseM3U() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Entry
+		wantErr bool
+	}{
+		{
+			name: "no namespace, tracks without a location",
+			data: `<playlist><trackList>
+				<track><title>Lost</title></track>
+				<track><location> https://example.com/a.mp3 </location><duration>61500</duration></track>
+			</trackList></playlist>`,
+			want: []Entry{{URL: "https://example.com/a.mp3", Title: "https://example.com/a.mp3", Duration: 61}},
+		},
+		{
+			name: "other meta ignored",
+			data: `<playlist xmlns="http://xspf.org/ns/0/" version="1"><trackList><track>
+				<location>https://example.com/a.mp3</location><title>Song</title>
+				<meta rel="http://example.com/rating">5</meta>
+			</track></trackList></playlist>`,
+			want: []Entry{{URL: "https://example.com/a.mp3", Title: "Song"}},
+		},
+		{
+			name:    "not xml",
+			data:    "just text",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			data:    `<playlist><trackList><track><location>https://example.com/a.mp3`,
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			data:    `<playlist><trackList><track><location>u</location><duration>long</duration></track></trackList></playlist>`,
+			wantErr: true,
+		},
+		{
+			name:    "other root",
+			data:    `<html><body/></html>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseXSPF(strings.NewReader(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseXSPF() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseXSPF() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	m3u, err := Import([]byte("#EXTM3U\nsong.mp3\n"), "/music")
+	if err != nil || len(m3u) != 1 || m3u[0].URL != "file:///music/song.mp3" {
+		t.Errorf("Import(m3u) = %+v, %v", m3u, err)
+	}
+	xspf, err := Import([]byte("\n  <?xml version=\"1.0\"?><playlist><trackList><track><location>u</location></track></trackList></playlist>"), "")
+	if err != nil || len(xspf) != 1 || xspf[0].URL != "u" {
+		t.Errorf("Import(xspf) = %+v, %v", xspf, err)
+	}
+}
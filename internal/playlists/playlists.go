@@ -0,0 +1,261 @@
+// Package playlists stores named playlists on disk, one JSON file each.
+package playlists
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kaboomer/internal/atomicfile"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for an unknown playlist id
+	ErrNotFound = errors.New("playlist not found")
+	// ErrInvalid is returned for edits that can't be applied, e.g. a bad index
+	ErrInvalid = errors.New("invalid playlist edit")
+)
+
+// Entry is one track in a playlist. ID is the YouTube (or local) track id,
+// so loading a playlist reuses cached downloads.
+type Entry struct {
+	ID       string `json:"id,omitempty"`
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist,omitempty"`
+	Duration int    `json:"duration,omitempty"` // Seconds
+}
+
+type Playlist struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Entries   []Entry   `json:"entries"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Summary describes a playlist without its entries
+type Summary struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Count     int       `json:"count"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Store struct {
+	dir string
+	mu  sync.Mutex // Serialises read-modify-write of playlist files
+}
+
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create playlist dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// List returns all playlists sorted by name. Files that can't be read are
+// logged and left out.
+func (s *Store) List() ([]Summary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []Summary{}
+	for _, file := range files {
+		p, err := s.load(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("Skipping playlist %s: %v", file, err)
+			continue
+		}
+		summaries = append(summaries, Summary{
+			ID:        p.ID,
+			Name:      p.Name,
+			Count:     len(p.Entries),
+			UpdatedAt: p.UpdatedAt,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return strings.ToLower(summaries[i].Name) < strings.ToLower(summaries[j].Name)
+	})
+	return summaries, nil
+}
+
+// Get returns one playlist
+func (s *Store) Get(id string) (*Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(id)
+}
+
+// Create saves a new playlist
+func (s *Store) Create(name string, entries []Entry) (*Playlist, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name required", ErrInvalid)
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+
+	now := time.Now()
+	p := &Playlist{
+		ID:        newID(),
+		Name:      name,
+		Entries:   entries,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Rename changes a playlist's name
+func (s *Store) Rename(id, name string) (*Playlist, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name required", ErrInvalid)
+	}
+	return s.update(id, func(p *Playlist) error {
+		p.Name = name
+		return nil
+	})
+}
+
+// Delete removes a playlist
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.load(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil {
+		return fmt.Errorf("failed to delete playlist: %w", err)
+	}
+	return nil
+}
+
+// Append adds entries to the end of a playlist
+func (s *Store) Append(id string, entries []Entry) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		p.Entries = append(p.Entries, entries...)
+		return nil
+	})
+}
+
+// Move moves the entry at index from to index to, clamping to the playlist bounds
+func (s *Store) Move(id string, from, to int) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		if from < 0 || from >= len(p.Entries) {
+			return fmt.Errorf("%w: index out of bounds", ErrInvalid)
+		}
+		to = max(0, min(to, len(p.Entries)-1))
+		entry := p.Entries[from]
+		p.Entries = append(p.Entries[:from], p.Entries[from+1:]...)
+		p.Entries = append(p.Entries[:to], append([]Entry{entry}, p.Entries[to:]...)...)
+		return nil
+	})
+}
+
+// RemoveEntry removes the entry at index
+func (s *Store) RemoveEntry(id string, index int) (*Playlist, error) {
+	return s.update(id, func(p *Playlist) error {
+		if index < 0 || index >= len(p.Entries) {
+			return fmt.Errorf("%w: index out of bounds", ErrInvalid)
+		}
+		p.Entries = append(p.Entries[:index], p.Entries[index+1:]...)
+		return nil
+	})
+}
+
+// update loads a playlist, applies fn and saves it
+func (s *Store) update(id string, fn func(p *Playlist) error) (*Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(p); err != nil {
+		return nil, err
+	}
+	p.UpdatedAt = time.Now()
+	if err := s.save(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// path returns the file of a playlist
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// load reads a playlist. s.mu must be locked.
+func (s *Store) load(id string) (*Playlist, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	var p Playlist
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist %s: %w", id, err)
+	}
+	return &p, nil
+}
+
+// save writes a playlist atomically. s.mu must be locked.
+func (s *Store) save(p *Playlist) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.Write(s.path(p.ID), data); err != nil {
+		return fmt.Errorf("failed to save playlist: %w", err)
+	}
+	return nil
+}
+
+func newID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validID rejects ids we didn't generate, so they can't escape the directory
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,130 @@
+package playlists
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "playlists"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// titles returns the entry titles of playlist id
+func titles(t *testing.T, s *Store, id string) []string {
+	t.Helper()
+	p, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range p.Entries {
+		out = append(out, e.Title)
+	}
+	return out
+}
+
+func TestStoreEdits(t *testing.T) {
+	s := newTestStore(t)
+	p, err := s.Create("  Mix ", []Entry{{URL: "a", Title: "A"}, {URL: "b", Title: "B"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Mix" {
+		t.Errorf("name = %q, want it trimmed", p.Name)
+	}
+
+	steps := []struct {
+		name string
+		edit func() (*Playlist, error)
+		want []string
+	}{
+		{"append", func() (*Playlist, error) { return s.Append(p.ID, []Entry{{URL: "c", Title: "C"}}) }, []string{"A", "B", "C"}},
+		{"move", func() (*Playlist, error) { return s.Move(p.ID, 0, 2) }, []string{"B", "C", "A"}},
+		{"move past the end", func() (*Playlist, error) { return s.Move(p.ID, 0, 10) }, []string{"C", "A", "B"}},
+		{"remove", func() (*Playlist, error) { return s.RemoveEntry(p.ID, 1) }, []string{"C", "B"}},
+	}
+	for _, step := range steps {
+		if _, err := step.edit(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := titles(t, s, p.ID); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("after %s: entries = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	if _, err := s.Rename(p.ID, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Get(p.ID); got.Name != "Renamed" {
+		t.Errorf("name = %q after rename", got.Name)
+	}
+	if err := s.Delete(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(p.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	s := newTestStore(t)
+	p, err := s.Create("Mix", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"empty name", func() error { _, err := s.Create(" ", nil); return err }(), ErrInvalid},
+		{"rename to nothing", func() error { _, err := s.Rename(p.ID, ""); return err }(), ErrInvalid},
+		{"move out of bounds", func() error { _, err := s.Move(p.ID, 0, 0); return err }(), ErrInvalid},
+		{"remove out of bounds", func() error { _, err := s.RemoveEntry(p.ID, -1); return err }(), ErrInvalid},
+		{"unknown id", func() error { _, err := s.Get("0123456789abcdef"); return err }(), ErrNotFound},
+		{"path in id", func() error { _, err := s.Get("../playlists/" + p.ID); return err }(), ErrNotFound},
+		{"delete unknown", s.Delete("abcd"), ErrNotFound},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	s := newTestStore(t)
+	for _, name := range []string{"beta", "Alpha", "gamma"} {
+		if _, err := s.Create(name, []Entry{{URL: "u", Title: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A damaged file doesn't hide the others
+	if err := os.WriteFile(filepath.Join(s.dir, "0badc0de.json"), []byte(`{"id": "0badc0de", "na`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range list {
+		names = append(names, p.Name)
+		if p.Count != 1 {
+			t.Errorf("%s has count %d, want 1", p.Name, p.Count)
+		}
+	}
+	if want := []string{"Alpha", "beta", "gamma"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+}
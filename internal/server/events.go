@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"kaboomer/internal/manager"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// positionInterval is how often the playback position is pushed while playing.
+// mpv doesn't emit an event for time-pos that is cheap enough to forward.
+const positionInterval = time.Second
+
+// eventFeed shares encoded snapshots among every event stream. Each manager
+// change and each position tick is built once, rather than once per
+// connected client, which costs an mpv round trip per status.
+type eventFeed struct {
+	mu      sync.Mutex
+	clients map[*feedClient]bool
+	// started numbers the publishes of each kind, sent is the newest handed out
+	started map[manager.ChangeKind]uint64
+	sent    map[manager.ChangeKind]uint64
+}
+
+// feedClient holds the snapshots one event stream hasn't written yet, the
+// newest of each kind. A slow stream skips straight to the newest.
+type feedClient struct {
+	mu      sync.Mutex
+	pending map[manager.ChangeKind][]byte
+	ready   chan struct{}
+}
+
+// subscribe returns a client receiving snapshots and a function that
+// unsubscribes it
+func (f *eventFeed) subscribe() (*feedClient, func()) {
+	c := &feedClient{pending: make(map[manager.ChangeKind][]byte), ready: make(chan struct{}, 1)}
+	f.mu.Lock()
+	if f.clients == nil {
+		f.clients = make(map[*feedClient]bool)
+		f.started = make(map[manager.ChangeKind]uint64)
+		f.sent = make(map[manager.ChangeKind]uint64)
+	}
+	f.clients[c] = true
+	f.mu.Unlock()
+	return c, func() {
+		f.mu.Lock()
+		delete(f.clients, c)
+		f.mu.Unlock()
+	}
+}
+
+// listening reports whether any event stream is subscribed
+func (f *eventFeed) listening() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clients) > 0
+}
+
+// publish encodes build's snapshot once and hands it to every client. Building
+// a status costs an mpv round trip, so it runs without f.mu held. The snapshot
+// only goes to streams subscribed when it started, later ones begin with a
+// newer snapshot of their own. One overtaken by a later publish of its kind
+// is dropped.
+func (f *eventFeed) publish(kind manager.ChangeKind, build func() interface{}) {
+	f.mu.Lock()
+	if len(f.clients) == 0 {
+		f.mu.Unlock()
+		return
+	}
+	clients := slices.Collect(maps.Keys(f.clients))
+	f.started[kind]++
+	seq := f.started[kind]
+	f.mu.Unlock()
+
+	data, err := json.Marshal(build())
+	if err != nil {
+		log.Printf("Event encode error: %v", err)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seq < f.sent[kind] {
+		return
+	}
+	f.sent[kind] = seq
+	for _, c := range clients {
+		c.mu.Lock()
+		c.pending[kind] = data
+		c.mu.Unlock()
+		select {
+		case c.ready <- struct{}{}:
+		default:
+			// Already signalled, the stream picks this up with the rest
+		}
+	}
+}
+
+// take returns the pending snapshots and clears them
+func (c *feedClient) take() map[manager.ChangeKind][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.pending = make(map[manager.ChangeKind][]byte)
+	return pending
+}
+
+// feedChanges publishes a snapshot for every manager change
+func (s *Server) feedChanges() {
+	changes, _ := s.manager.Subscribe()
+	for kind := range changes {
+		switch kind {
+		case manager.ChangeStatus:
+			s.events.publish(kind, func() interface{} { return s.buildStatus() })
+		case manager.ChangeQueue, manager.ChangeProgress:
+			// Progress is shown on the queue items
+			s.events.publish(manager.ChangeQueue, func() interface{} { return s.buildQueue() })
+		}
+	}
+}
+
+// feedPositions publishes a status snapshot every positionInterval while
+// something plays and anyone is listening
+func (s *Server) feedPositions() {
+	ticker := time.NewTicker(positionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.events.listening() || !s.isPlaying() {
+			continue
+		}
+		s.events.publish(manager.ChangeStatus, func() interface{} { return s.buildStatus() })
+	}
+}
+
+// handleEvents streams status and queue updates as Server-Sent Events.
+// Each message is a full snapshot, so clients can simply replace their state:
+//
+//	event: status
+//	data: {"current_title": ..., "position": ...}
+//
+//	event: queue
+//	data: [{"id": ..., "current": true}, ...]
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	write := func(kind manager.ChangeKind, data []byte) bool {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Initial snapshot, later ones come from the shared feed
+	initial := []struct {
+		kind    manager.ChangeKind
+		payload interface{}
+	}{
+		{manager.ChangeStatus, s.buildStatus()},
+		{manager.ChangeQueue, s.buildQueue()},
+	}
+	for _, snap := range initial {
+		data, err := json.Marshal(snap.payload)
+		if err != nil {
+			log.Printf("Event encode error: %v", err)
+			continue
+		}
+		if !write(snap.kind, data) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.ready:
+			pending := client.take()
+			for _, kind := range []manager.ChangeKind{manager.ChangeStatus, manager.ChangeQueue} {
+				if data, ok := pending[kind]; ok && !write(kind, data) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// isPlaying reports whether mpv has a file loaded and is not paused
+func (s *Server) isPlaying() bool {
+	if idle, err := s.manager.GetProperty("idle-active"); err == nil {
+		if idleBool, ok := idle.(bool); ok && idleBool {
+			return false
+		}
+	}
+	if paused, err := s.manager.GetProperty("pause"); err == nil {
+		if pausedBool, ok := paused.(bool); ok && pausedBool {
+			return false
+		}
+	}
+	return true
+}
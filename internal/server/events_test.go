@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/manager"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one Server-Sent Event
+type sseEvent struct {
+	kind string
+	data string
+}
+
+// readEvents parses a Server-Sent Events stream onto a channel
+func readEvents(body *bufio.Scanner) <-chan sseEvent {
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		for body.Scan() {
+			line := body.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.kind = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// nextEvent returns the next event of kind, skipping others
+func nextEvent(t *testing.T, events <-chan sseEvent, kind string) sseEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended waiting for a %s event", kind)
+			}
+			if ev.kind == kind {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %s event", kind)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, _ := newTestServer(t, downloader.Entry{ID: "a1", Title: "Song", Artist: "Band"})
+	go s.feedChanges()
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+	events := readEvents(bufio.NewScanner(resp.Body))
+
+	// A status and a queue snapshot right away
+	nextEvent(t, events, "status")
+	var queue []queueResponseItem
+	if err := json.Unmarshal([]byte(nextEvent(t, events, "queue").data), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 0 {
+		t.Errorf("initial queue = %+v, want it empty", queue)
+	}
+
+	// Then a fresh snapshot for every change
+	eventually(t, "the stream to subscribe", s.events.listening)
+	if _, err := s.manager.AddCached([]string{"a1"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(nextEvent(t, events, "queue").data), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].Title != "Song" || queue[0].Status != string(manager.StatusReady) {
+		t.Errorf("queue = %+v, want the ready Song", queue)
+	}
+
+	// Disconnecting unsubscribes
+	cancel()
+	eventually(t, "the stream to unsubscribe", func() bool { return !s.events.listening() })
+}
+
+func TestEventFeedKeepsNewest(t *testing.T) {
+	var feed eventFeed
+	c, unsubscribe := feed.subscribe()
+	defer unsubscribe()
+
+	for i := range 3 {
+		feed.publish(manager.ChangeStatus, func() interface{} { return i })
+	}
+	feed.publish(manager.ChangeQueue, func() interface{} { return "q" })
+
+	select {
+	case <-c.ready:
+	default:
+		t.Fatal("client not signalled")
+	}
+	pending := c.take()
+	if got := string(pending[manager.ChangeStatus]); got != "2" {
+		t.Errorf("pending status = %s, want the newest, 2", got)
+	}
+	if got := string(pending[manager.ChangeQueue]); got != `"q"` {
+		t.Errorf("pending queue = %s, want \"q\"", got)
+	}
+	if len(c.take()) != 0 {
+		t.Error("take left snapshots pending")
+	}
+}
+
+func TestEventFeedBuildsUnlocked(t *testing.T) {
+	var feed eventFeed
+	c, unsubscribe := feed.subscribe()
+	defer unsubscribe()
+
+	building := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		feed.publish(manager.ChangeStatus, func() interface{} {
+			close(building)
+			<-release
+			return "old"
+		})
+	}()
+	<-building
+
+	// Neither a new stream nor a newer snapshot waits for the slow build
+	late, unsubscribeLate := feed.subscribe()
+	defer unsubscribeLate()
+	feed.publish(manager.ChangeStatus, func() interface{} { return "new" })
+	close(release)
+	<-done
+
+	if got := string(c.take()[manager.ChangeStatus]); got != `"new"` {
+		t.Errorf("pending status = %s, want the newer \"new\"", got)
+	}
+	if got := string(late.take()[manager.ChangeStatus]); got != `"new"` {
+		t.Errorf("late stream's pending status = %s, want \"new\"", got)
+	}
+}
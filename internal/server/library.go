@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/manager"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type libraryItem struct {
+	ID           string     `json:"id"`
+	Title        string     `json:"title"`
+	Artist       string     `json:"artist"`
+	Duration     float64    `json:"duration,omitempty"`
+	Format       string     `json:"format"`
+	Size         int64      `json:"size"`
+	DownloadedAt time.Time  `json:"downloaded_at"`
+	LastPlayedAt *time.Time `json:"last_played_at,omitempty"`
+}
+
+// handleLibrary lists cached tracks, which can be played without a network.
+// Query parameters:
+//
+//	q       matches title or artist
+//	artist  matches artist only
+//	title   matches title only
+//	sort    last_played (default), downloaded, title or artist
+//
+// Matching is a case-insensitive substring match.
+func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.ToLower(query.Get("q"))
+	artist := strings.ToLower(query.Get("artist"))
+	title := strings.ToLower(query.Get("title"))
+
+	var entries []downloader.Entry
+	for _, e := range s.manager.Library() {
+		t, a := strings.ToLower(e.Title), strings.ToLower(e.Artist)
+		if q != "" && !strings.Contains(t, q) && !strings.Contains(a, q) {
+			continue
+		}
+		if artist != "" && !strings.Contains(a, artist) {
+			continue
+		}
+		if title != "" && !strings.Contains(t, title) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	var less func(a, b downloader.Entry) bool
+	switch query.Get("sort") {
+	case "", "last_played":
+		// Never played tracks go last, newest downloads first among them
+		less = func(a, b downloader.Entry) bool {
+			if !a.LastPlayedAt.Equal(b.LastPlayedAt) {
+				return a.LastPlayedAt.After(b.LastPlayedAt)
+			}
+			return a.DownloadedAt.After(b.DownloadedAt)
+		}
+	case "downloaded":
+		less = func(a, b downloader.Entry) bool { return a.DownloadedAt.After(b.DownloadedAt) }
+	case "title":
+		less = func(a, b downloader.Entry) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	case "artist":
+		less = func(a, b downloader.Entry) bool {
+			if !strings.EqualFold(a.Artist, b.Artist) {
+				return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+			}
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+	default:
+		http.Error(w, "Unknown sort", http.StatusBadRequest)
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	resp := make([]libraryItem, len(entries))
+	for i, e := range entries {
+		resp[i] = libraryItem{
+			ID:           e.ID,
+			Title:        e.Title,
+			Artist:       e.Artist,
+			Duration:     e.Duration,
+			Format:       e.Format,
+			Size:         e.Size,
+			DownloadedAt: e.DownloadedAt,
+		}
+		if !e.LastPlayedAt.IsZero() {
+			played := e.LastPlayedAt
+			resp[i].LastPlayedAt = &played
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+type LibraryAddRequest struct {
+	IDs  []string `json:"ids"`
+	Play bool     `json:"play,omitempty"` // Start the first track now instead of appending
+}
+
+// handleLibraryAdd queues cached tracks without going through yt-dlp
+func (s *Server) handleLibraryAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LibraryAddRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "IDs required", http.StatusBadRequest)
+		return
+	}
+
+	queueIDs, err := s.manager.AddCached(req.IDs, req.Play)
+	if err != nil {
+		if errors.Is(err, manager.ErrNotCached) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Library add error: %v", err)
+		http.Error(w, "Failed to queue tracks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"queue_ids": queueIDs})
+}
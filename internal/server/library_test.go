@@ -0,0 +1,99 @@
+package server
+
+import (
+	"kaboomer/internal/downloader"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLibrary(t *testing.T) {
+	now := time.Now()
+	s, _ := newTestServer(t,
+		downloader.Entry{ID: "a1", Title: "Blue", Artist: "Band", DownloadedAt: now.Add(-3 * time.Hour), LastPlayedAt: now.Add(-time.Minute)},
+		downloader.Entry{ID: "b2", Title: "Red", Artist: "Other", DownloadedAt: now.Add(-2 * time.Hour)},
+		downloader.Entry{ID: "c3", Title: "Green", Artist: "Band", DownloadedAt: now.Add(-time.Hour)},
+	)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a1", "c3", "b2"}}, // Played first, then newest downloads
+		{"?sort=downloaded", []string{"c3", "b2", "a1"}},
+		{"?sort=title", []string{"a1", "c3", "b2"}},
+		{"?q=band&sort=title", []string{"a1", "c3"}},
+		{"?artist=OTHER", []string{"b2"}},
+		{"?title=re", []string{"c3", "b2"}},
+		{"?q=nothing", []string{}},
+	}
+	for _, tt := range tests {
+		rec := call(s.handleLibrary, http.MethodGet, "/api/library"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tt.query, rec.Code)
+			continue
+		}
+		var items []libraryItem
+		decode(t, rec, &items)
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%s: ids = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	if rec := call(s.handleLibrary, http.MethodPost, "/api/library", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLibraryAdd(t *testing.T) {
+	s, ytdlp := newTestServer(t,
+		downloader.Entry{ID: "a1", Title: "Blue", Artist: "Band"},
+		downloader.Entry{ID: "b2", Title: "Red", Artist: "Other"},
+	)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"bad body", "{", http.StatusBadRequest},
+		{"no ids", `{"ids":[]}`, http.StatusBadRequest},
+		{"not cached", `{"ids":["a1","gone"]}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := call(s.handleLibraryAdd, http.MethodPost, "/api/library/add", tt.body); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+	if n := len(s.manager.GetQueue()); n != 0 {
+		t.Fatalf("failed adds queued %d items", n)
+	}
+
+	rec := call(s.handleLibraryAdd, http.MethodPost, "/api/library/add", `{"ids":["b2","a1"],"play":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add = %d %s", rec.Code, rec.Body)
+	}
+	var resp map[string][]string
+	decode(t, rec, &resp)
+
+	queue := s.manager.GetQueue()
+	if len(queue) != 2 || len(resp["queue_ids"]) != 2 {
+		t.Fatalf("queued %d items, returned %v; want 2", len(queue), resp)
+	}
+	for i, item := range queue {
+		if item.QueueID != resp["queue_ids"][i] {
+			t.Errorf("queue id %d = %q, want %q", i, resp["queue_ids"][i], item.QueueID)
+		}
+	}
+	if cur, _, ok := s.manager.GetCurrent(); !ok || cur.ID != "b2" {
+		t.Errorf("playing %q, want b2", cur.ID)
+	}
+	if calls := ytdlp.Calls(t); len(calls) != 0 {
+		t.Errorf("yt-dlp ran for cached tracks: %q", calls)
+	}
+}
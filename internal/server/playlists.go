@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kaboomer/internal/playlists"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxPlaylistImport bounds the size of an uploaded M3U or XSPF file
+const maxPlaylistImport = 10 << 20
+
+type PlaylistRequest struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name,omitempty"`
+	Entries   []playlists.Entry `json:"entries,omitempty"`
+	FromQueue bool              `json:"from_queue,omitempty"` // Use the current queue as the entries
+	From      int               `json:"from,omitempty"`       // Move source index
+	Index     int               `json:"index,omitempty"`      // Move target or remove index
+	Mode      string            `json:"mode,omitempty"`       // Load mode: replace (default) or append
+}
+
+// handlePlaylists lists playlists on GET and creates one on POST
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.playlists.List()
+		if err != nil {
+			playlistError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req PlaylistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		entries := req.Entries
+		if req.FromQueue {
+			entries = s.queueEntries()
+		}
+		p, err := s.playlists.Create(req.Name, entries)
+		s.writePlaylist(w, p, err)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePlaylistGet(w http.ResponseWriter, r *http.Request) {
+	p, err := s.playlists.Get(r.URL.Query().Get("id"))
+	s.writePlaylist(w, p, err)
+}
+
+func (s *Server) handlePlaylistRename(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	p, err := s.playlists.Rename(req.ID, req.Name)
+	s.writePlaylist(w, p, err)
+}
+
+func (s *Server) handlePlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.playlists.Delete(req.ID); err != nil {
+		playlistError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePlaylistMove(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	p, err := s.playlists.Move(req.ID, req.From, req.Index)
+	s.writePlaylist(w, p, err)
+}
+
+func (s *Server) handlePlaylistRemove(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	p, err := s.playlists.RemoveEntry(req.ID, req.Index)
+	s.writePlaylist(w, p, err)
+}
+
+func (s *Server) handlePlaylistAppend(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	entries := req.Entries
+	if req.FromQueue {
+		entries = s.queueEntries()
+	}
+	p, err := s.playlists.Append(req.ID, entries)
+	s.writePlaylist(w, p, err)
+}
+
+// handlePlaylistLoad puts a playlist in the queue. replace empties the queue,
+// the track playing included, and starts the first track; append adds the
+// tracks after what is queued.
+func (s *Server) handlePlaylistLoad(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlaylistRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Mode != "" && req.Mode != "replace" && req.Mode != "append" {
+		http.Error(w, "Unknown mode", http.StatusBadRequest)
+		return
+	}
+
+	p, err := s.playlists.Get(req.ID)
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+
+	reqs := make([]PlayRequest, len(p.Entries))
+	for i, e := range p.Entries {
+		reqs[i] = PlayRequest{ID: e.ID, URL: e.URL, Title: e.Title, Artist: e.Artist}
+	}
+
+	replace := req.Mode != "append"
+	if replace {
+		s.manager.ClearAll()
+	}
+	s.queueTracks(reqs, replace)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePlaylistImport creates a playlist from an uploaded extended M3U or
+// XSPF file sent as the request body. ?name= names it.
+func (s *Server) handlePlaylistImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPlaylistImport))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	// Uploads have no location of their own, relative paths are taken to be
+	// in the music directory
+	base := ""
+	if s.local != nil {
+		base = s.local.Root()
+	}
+	entries, err := playlists.Import(data, base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Imported playlist"
+	}
+	p, err := s.playlists.Create(name, entries)
+	s.writePlaylist(w, p, err)
+}
+
+// handlePlaylistExport downloads a playlist as ?format=m3u (default) or xspf
+func (s *Server) handlePlaylistExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	p, err := s.playlists.Get(query.Get("id"))
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+
+	write, ext, contentType := playlists.WriteM3U, "m3u", "audio/x-mpegurl"
+	switch query.Get("format") {
+	case "", "m3u":
+	case "xspf":
+		write, ext, contentType = playlists.WriteXSPF, "xspf", "application/xspf+xml"
+	default:
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(p.Name)+"."+ext))
+	if err := write(w, p); err != nil {
+		log.Printf("Playlist export error: %v", err)
+	}
+}
+
+// exportFilename keeps the characters of a playlist name that are safe in a filename
+func exportFilename(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	if safe == "" {
+		return "playlist"
+	}
+	return safe
+}
+
+// queueEntries converts the current queue to playlist entries
+func (s *Server) queueEntries() []playlists.Entry {
+	queue := s.manager.GetQueue()
+	entries := make([]playlists.Entry, len(queue))
+	for i, item := range queue {
+		entries[i] = playlists.Entry{ID: item.ID, URL: item.URL, Title: item.Title, Artist: item.Artist}
+	}
+	return entries
+}
+
+// decodePlaylistRequest checks for POST and decodes the body
+func decodePlaylistRequest(w http.ResponseWriter, r *http.Request) (PlaylistRequest, bool) {
+	var req PlaylistRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// writePlaylist responds with p, or with the error from the store
+func (s *Server) writePlaylist(w http.ResponseWriter, p *playlists.Playlist, err error) {
+	if err != nil {
+		playlistError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// playlistError maps playlist store errors to HTTP responses
+func playlistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, playlists.ErrNotFound):
+		http.Error(w, "Playlist not found", http.StatusNotFound)
+	case errors.Is(err, playlists.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Playlist error: %v", err)
+		http.Error(w, "Playlist operation failed", http.StatusInternalServerError)
+	}
+}
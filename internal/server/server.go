@@ -1,23 +1,40 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/localmusic"
 	"kaboomer/internal/manager"
+	"kaboomer/internal/playlists"
 	"kaboomer/internal/youtube"
 	"log"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Server struct {
 	manager   *manager.Manager
 	yt        *youtube.Service
+	local     *localmusic.Library // nil when no music directory is configured
+	playlists *playlists.Store
 	staticDir string
+	events    eventFeed // See events.go
 }
 
-func New(m *manager.Manager, yt *youtube.Service, staticDir string) *Server {
+func New(m *manager.Manager, yt *youtube.Service, local *localmusic.Library, lists *playlists.Store, staticDir string) *Server {
 	return &Server{
 		manager:   m,
 		yt:        yt,
+		local:     local,
+		playlists: lists,
 		staticDir: staticDir,
 	}
 }
@@ -34,35 +51,157 @@ func (s *Server) Start(port string) error {
 	mux.HandleFunc("/api/play", s.handlePlay)
 	mux.HandleFunc("/api/control", s.handleControl)
 	mux.HandleFunc("/api/status", s.handleStatus)
+	mux.HandleFunc("/api/events", s.handleEvents)
 	mux.HandleFunc("/api/queue", s.handleQueue)
 	mux.HandleFunc("/api/queue/add", s.handleQueueAdd)
 	mux.HandleFunc("/api/queue/play", s.handleQueuePlay)
 	mux.HandleFunc("/api/queue/add_batch", s.handleQueueAddBatch)
 	mux.HandleFunc("/api/queue/clear", s.handleQueueClear)
+	mux.HandleFunc("/api/queue/remove", s.handleQueueRemove)
+	mux.HandleFunc("/api/queue/move", s.handleQueueMove)
+	mux.HandleFunc("/api/queue/insert_next", s.handleQueueInsertNext)
+	mux.HandleFunc("/api/queue/add_url", s.handleQueueAddURL)
+	mux.HandleFunc("/api/queue/retry", s.handleQueueRetry)
 	mux.HandleFunc("/api/play_batch", s.handlePlayBatch)
+	mux.HandleFunc("/api/cache", s.handleCache)
+	mux.HandleFunc("/api/diagnostics", s.handleDiagnostics)
+	mux.HandleFunc("/api/library", s.handleLibrary)
+	mux.HandleFunc("/api/library/add", s.handleLibraryAdd)
+	mux.HandleFunc("/api/local/scan", s.handleLocalScan)
+	mux.HandleFunc("/api/playlists", s.handlePlaylists)
+	mux.HandleFunc("/api/playlists/get", s.handlePlaylistGet)
+	mux.HandleFunc("/api/playlists/rename", s.handlePlaylistRename)
+	mux.HandleFunc("/api/playlists/delete", s.handlePlaylistDelete)
+	mux.HandleFunc("/api/playlists/move", s.handlePlaylistMove)
+	mux.HandleFunc("/api/playlists/remove", s.handlePlaylistRemove)
+	mux.HandleFunc("/api/playlists/append", s.handlePlaylistAppend)
+	mux.HandleFunc("/api/playlists/load", s.handlePlaylistLoad)
+	mux.HandleFunc("/api/playlists/import", s.handlePlaylistImport)
+	mux.HandleFunc("/api/playlists/export", s.handlePlaylistExport)
+
+	go s.feedChanges()
+	go s.feedPositions()
 
 	log.Printf("Server listening on %s", port)
 	return http.ListenAndServe(port, mux)
 }
 
+// searchResult is a search hit tagged with where it came from
+type searchResult struct {
+	youtube.SearchResult
+	Source string `json:"source"` // youtube or local
+}
+
+// localSearchLimit caps local hits so they don't bury the YouTube results
+const localSearchLimit = 10
+
+// handleSearch searches the local music directory and YouTube. ?type= picks
+// plain YouTube (video, the default) or a YouTube Music section (song, album,
+// artist, playlist). ?size= sets the page size and ?page= (from 1) or
+// ?offset= picks the page; a short page is the last one. Local hits come
+// first on the first page of video and song searches; if YouTube is
+// unreachable they are returned on their own.
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
+	params := r.URL.Query()
+	query := params.Get("q")
 	if query == "" {
 		http.Error(w, "Missing query", http.StatusBadRequest)
 		return
 	}
+	searchType, err := youtube.ParseSearchType(params.Get("type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	results, err := s.yt.Search(query)
+	size, ok1 := intParam(params, "size", youtube.DefaultPageSize)
+	offset, ok2 := intParam(params, "offset", 0)
+	page, ok3 := intParam(params, "page", 0)
+	if !ok1 || !ok2 || !ok3 {
+		http.Error(w, "Invalid page, offset or size", http.StatusBadRequest)
+		return
+	}
+	size = min(max(size, 1), youtube.MaxPageSize)
+	if page > 0 {
+		offset = (page - 1) * size
+	}
+	opts := youtube.SearchOptions{Type: searchType, Offset: offset, Size: size}
+
+	results := []searchResult{}
+	if s.local != nil && opts.Offset == 0 && (searchType == youtube.TypeVideo || searchType == youtube.TypeSong) {
+		for _, t := range s.local.Search(query, localSearchLimit) {
+			results = append(results, searchResult{
+				SearchResult: youtube.SearchResult{
+					ID:       t.ID,
+					Title:    t.Title,
+					Uploader: t.Artist,
+					Duration: int(t.Duration),
+					URL:      t.URL,
+					Type:     youtube.TypeSong,
+					Artist:   t.Artist,
+					Album:    t.Album,
+					Track:    t.Title,
+				},
+				Source: "local",
+			})
+		}
+	}
+
+	ytResults, err := s.yt.Search(r.Context(), query, opts)
 	if err != nil {
+		if r.Context().Err() != nil {
+			// The client went away, nobody is listening
+			return
+		}
 		log.Printf("Search error: %v", err)
-		http.Error(w, "Search failed", http.StatusInternalServerError)
-		return
+		if len(results) == 0 {
+			if errors.Is(err, context.DeadlineExceeded) {
+				http.Error(w, "Search timed out", http.StatusGatewayTimeout)
+				return
+			}
+			http.Error(w, "Search failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, res := range ytResults {
+		results = append(results, searchResult{SearchResult: res, Source: "youtube"})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
 
+// intParam reads a non-negative integer query parameter, def if it is absent
+func intParam(params url.Values, name string, def int) (int, bool) {
+	v := params.Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
+
+// handleLocalScan re-reads the music directory, e.g. after swapping the USB stick
+func (s *Server) handleLocalScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.local == nil {
+		http.Error(w, "No music directory configured", http.StatusNotFound)
+		return
+	}
+
+	if err := s.local.Scan(); err != nil {
+		log.Printf("Local scan error: %v", err)
+		http.Error(w, "Scan failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"tracks": s.local.Len()})
+}
+
 type PlayRequest struct {
 	ID    string `json:"id"`
 	URL   string `json:"url"`
@@ -86,7 +225,12 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL required", http.StatusBadRequest)
 		return
 	}
+	if err := s.manager.CheckURL(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
+	s.fillMetadata(r.Context(), &req)
 	if req.Title == "" {
 		req.Title = "Unknown Track"
 	}
@@ -99,9 +243,34 @@ func (s *Server) handlePlay(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// fillMetadata looks up the title and uploader of a track queued by URL alone.
+// Anything found in a recent search is answered from the youtube cache.
+func (s *Server) fillMetadata(ctx context.Context, req *PlayRequest) {
+	if req.Title != "" || !strings.HasPrefix(req.URL, "http") {
+		return
+	}
+	meta, err := s.yt.Metadata(ctx, req.URL)
+	if err != nil {
+		log.Printf("Metadata lookup failed for %s: %v", req.URL, err)
+		return
+	}
+	req.Title = meta.Title
+	if req.Artist == "" {
+		req.Artist = strings.TrimSuffix(meta.Uploader, " - Topic")
+	}
+	if req.ID == "" {
+		req.ID = meta.ID
+	}
+}
+
 type ControlRequest struct {
-	Action string  `json:"action"` // pause, resume, next, prev, seek
+	Action string  `json:"action"` // pause, resume, next, prev, seek, volume, mode, normalize, gapless, crossfade
 	Value  float64 `json:"value,omitempty"`
+	// For mode: off, repeat-all, repeat-one, shuffle; Value is the shuffle seed.
+	// For normalize: off, track, album; Value is the target LUFS, 0 keeps it.
+	// gapless and crossfade take no Mode: Value 1 turns gapless on and 0 off,
+	// and is the crossfade length in seconds.
+	Mode string `json:"mode,omitempty"`
 }
 
 func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
@@ -128,6 +297,37 @@ func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
 		err = s.manager.Seek(req.Value)
 	case "volume":
 		err = s.manager.SetVolume(req.Value)
+	case "mode":
+		// A seed must survive the trip to uint64 unchanged
+		if req.Value < 0 || req.Value >= math.MaxUint64 || req.Value != math.Trunc(req.Value) {
+			http.Error(w, "Seed must be a whole number", http.StatusBadRequest)
+			return
+		}
+		if err := s.manager.SetMode(manager.PlayMode(req.Mode), uint64(req.Value)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "normalize":
+		n := s.manager.GetNormalization()
+		n.Mode = manager.NormalizeMode(req.Mode)
+		if req.Value != 0 {
+			n.TargetLUFS = req.Value
+		}
+		if err := s.manager.SetNormalization(n); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "gapless", "crossfade":
+		t := s.manager.GetTransition()
+		if req.Action == "gapless" {
+			t.Gapless = req.Value != 0
+		} else {
+			t.Crossfade = req.Value
+		}
+		if err := s.manager.SetTransition(t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	default:
 		http.Error(w, "Unknown action", http.StatusBadRequest)
 		return
@@ -142,33 +342,48 @@ func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.buildStatus())
+}
+
+// buildStatus assembles the playback status shared by /api/status and /api/events
+func (s *Server) buildStatus() map[string]interface{} {
 	status := map[string]interface{}{
 		"current_title": s.manager.GetStatus(),
 		"current_artist": "", // New field
 		"position":      0.0,
 		"duration":      0.0,
 		"volume":        100.0,
+		"paused":        false,
 		"is_loading":    false,
+		"current":       nil,
+		"current_index": -1,
 	}
 
-	// Try to find artist from queue based on current title or target
-	currentTitle := status["current_title"].(string)
-	
-	if target := s.manager.GetPlayTarget(); target != nil {
+	status["player"] = s.manager.PlayerHealth()
+
+	mode, seed := s.manager.GetMode()
+	status["mode"] = mode
+	if mode == manager.ModeShuffle {
+		status["shuffle_seed"] = seed
+	}
+	status["normalization"] = s.manager.GetNormalization()
+	status["transition"] = s.manager.GetTransition()
+
+	if current, index, ok := s.manager.GetCurrent(); ok {
+		status["current"] = current
+		status["current_index"] = index
+		status["current_title"] = current.Title
+		status["current_artist"] = current.Artist
+	}
+
+	if target, ok := s.manager.GetPlayTarget(); ok {
 		status["is_loading"] = true
 		status["current_title"] = target.Title
 		status["current_artist"] = target.Artist
-	} else if currentTitle != "Idle" {
-		// Look up artist in queue
-		// This is inefficient but queue is small
-		// Better: Manager could track current item
-		queue := s.manager.GetQueue()
-		for _, item := range queue {
-			// Title might match filename or title
-			if item.Title == currentTitle || item.LocalPath == currentTitle /* heuristic */ {
-				status["current_artist"] = item.Artist
-				break
-			}
+		if p := target.Progress; p != nil {
+			status["progress"] = p
+			status["stalled"] = p.Stalled()
 		}
 	}
 
@@ -187,43 +402,64 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 			status["volume"] = volFloat
 		}
 	}
+	if paused, err := s.manager.GetProperty("pause"); err == nil {
+		if pausedBool, ok := paused.(bool); ok {
+			status["paused"] = pausedBool
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	return status
 }
 
 func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
-	queue := s.manager.GetQueue()
-	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.buildQueue())
+}
+
+type queueResponseItem struct {
+	QueueID  string `json:"queue_id"`
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	Status   string `json:"status"`
+	Current  bool   `json:"current"`
+	Filename string `json:"filename"` // Frontend uses this key sometimes
+
+	Error    string     `json:"error,omitempty"`
+	Attempts int        `json:"attempts,omitempty"`
+	RetryAt  *time.Time `json:"retry_at,omitempty"` // Next automatic download retry
+
+	Progress *downloader.Progress `json:"progress,omitempty"`
+	Stalled  bool                 `json:"stalled,omitempty"` // No data for downloader.StallTimeout
+}
+
+// buildQueue maps the manager queue to the structure the frontend expects
+func (s *Server) buildQueue() []queueResponseItem {
+	queue, currentIndex := s.manager.GetQueueState()
+
 	// Map to structure frontend expects (PlaylistItem-ish)
 	// Frontend expects: filename, title, current?
-	// We'll use our queue structure but we need to identify 'current'.
-	// We can check title against status.current_title
-	currentTitle := s.manager.GetStatus()
-
-	type queueResponseItem struct {
-		ID       string `json:"id"`
-		Title    string `json:"title"`
-		Artist   string `json:"artist"`
-		Status   string `json:"status"`
-		Current  bool   `json:"current"`
-		Filename string `json:"filename"` // Frontend uses this key sometimes
-	}
-	
+
 	resp := make([]queueResponseItem, len(queue))
 	for i, item := range queue {
 		resp[i] = queueResponseItem{
+			QueueID:  item.QueueID,
 			ID:       item.ID,
 			Title:    item.Title,
 			Artist:   item.Artist,
 			Status:   string(item.Status),
-			Current:  item.Title == currentTitle, // Rough heuristic
+			Current:  i == currentIndex,
 			Filename: item.Title, // Fallback
+			Error:    item.Error,
+			Attempts: item.Attempts,
+			RetryAt:  item.RetryAt,
+		}
+		if p := item.Progress; p != nil {
+			resp[i].Progress = p
+			resp[i].Stalled = p.Stalled()
 		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	return resp
 }
 
 func (s *Server) handleQueueAdd(w http.ResponseWriter, r *http.Request) {
@@ -242,7 +478,12 @@ func (s *Server) handleQueueAdd(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "URL required", http.StatusBadRequest)
 		return
 	}
+	if err := s.manager.CheckURL(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
+	s.fillMetadata(r.Context(), &req)
 	if req.Artist == "" {
 		req.Artist = "Unknown Artist"
 	}
@@ -286,6 +527,117 @@ func (s *Server) handleQueueClear(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+type QueueEditRequest struct {
+	QueueID string `json:"queue_id"`
+	Index   int    `json:"index"` // Target index for move
+}
+
+func (s *Server) handleQueueRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QueueEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Remove(req.QueueID); err != nil {
+		queueEditError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleQueueMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QueueEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Move(req.QueueID, req.Index); err != nil {
+		queueEditError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleQueueInsertNext(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PlayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if req.URL == "" {
+		http.Error(w, "URL required", http.StatusBadRequest)
+		return
+	}
+	if err := s.manager.CheckURL(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	s.fillMetadata(r.Context(), &req)
+	if req.Artist == "" {
+		req.Artist = "Unknown Artist"
+	}
+
+	queueID := s.manager.InsertNext(req.URL, req.Title, req.ID, req.Artist)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"queue_id": queueID})
+}
+
+// handleQueueRetry downloads a failed item again, or every failed item without a queue_id
+func (s *Server) handleQueueRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty body retries everything
+	var req QueueEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	n, err := s.manager.Retry(req.QueueID)
+	if err != nil {
+		queueEditError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"retried": n})
+}
+
+// queueEditError maps manager errors from queue edits to HTTP responses
+func queueEditError(w http.ResponseWriter, err error) {
+	if errors.Is(err, manager.ErrItemNotFound) {
+		http.Error(w, "Queue item not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, manager.ErrNotFailed) {
+		http.Error(w, "Queue item has not failed", http.StatusConflict)
+		return
+	}
+	log.Printf("Queue edit error: %v", err)
+	http.Error(w, "Queue edit failed", http.StatusInternalServerError)
+}
+
 func (s *Server) handleQueueAddBatch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -298,16 +650,7 @@ func (s *Server) handleQueueAddBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, req := range reqs {
-		if req.URL == "" {
-			continue
-		}
-		if req.Artist == "" {
-			req.Artist = "Unknown Artist"
-		}
-		s.manager.Add(req.URL, req.Title, req.ID, req.Artist)
-	}
-
+	s.queueTracks(reqs, false)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -323,23 +666,132 @@ func (s *Server) handlePlayBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(reqs) == 0 {
+	s.queueTracks(reqs, true)
+	w.WriteHeader(http.StatusOK)
+}
+
+type AddURLRequest struct {
+	URL     string `json:"url"`
+	Offset  int    `json:"offset,omitempty"`  // Entries to skip from the start of the playlist
+	Limit   int    `json:"limit,omitempty"`   // 0 for youtube.MaxExpand
+	Shuffle bool   `json:"shuffle,omitempty"` // Shuffle the entries before queueing them
+	Play    bool   `json:"play,omitempty"`    // Start the first entry now
+}
+
+// handleQueueAddURL expands a playlist, album, mix or channel URL and queues
+// every entry
+func (s *Server) handleQueueAddURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AddURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if req.URL == "" {
+		http.Error(w, "URL required", http.StatusBadRequest)
+		return
+	}
+	if err := youtube.CheckURL(req.URL); err != nil {
+		http.Error(w, "Only http and https URLs can be expanded", http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.yt.Expand(r.Context(), req.URL, req.Offset, req.Limit)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		log.Printf("Expand error: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "Resolving URL timed out", http.StatusGatewayTimeout)
+			return
+		}
+		http.Error(w, "Failed to resolve URL", http.StatusBadGateway)
 		return
 	}
+	if req.Shuffle {
+		rand.Shuffle(len(results), func(i, j int) {
+			results[i], results[j] = results[j], results[i]
+		})
+	}
 
-	// Play first
-	if reqs[0].Artist == "" {
-		reqs[0].Artist = "Unknown Artist"
+	reqs := make([]PlayRequest, len(results))
+	for i, res := range results {
+		reqs[i] = PlayRequest{ID: res.ID, URL: res.URL, Title: res.Title, Artist: res.Artist}
 	}
-	s.manager.Play(reqs[0].URL, reqs[0].Title, reqs[0].ID, reqs[0].Artist)
-	
-	// Add rest
-	for i := 1; i < len(reqs); i++ {
-		if reqs[i].Artist == "" {
-			reqs[i].Artist = "Unknown Artist"
+	s.queueTracks(reqs, req.Play)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"added": len(reqs)})
+}
+
+// queueTracks adds tracks to the queue. With play, the first one starts now
+// and the rest follow it. Entries without a URL, and local files outside the
+// music directory, are skipped.
+func (s *Server) queueTracks(reqs []PlayRequest, play bool) {
+	for _, req := range reqs {
+		if req.URL == "" {
+			continue
 		}
-		s.manager.Add(reqs[i].URL, reqs[i].Title, reqs[i].ID, reqs[i].Artist)
+		if err := s.manager.CheckURL(req.URL); err != nil {
+			log.Printf("Skipping %s: %v", req.URL, err)
+			continue
+		}
+		if req.Artist == "" {
+			req.Artist = "Unknown Artist"
+		}
+		if play {
+			s.manager.Play(req.URL, req.Title, req.ID, req.Artist)
+			play = false
+			continue
+		}
+		s.manager.Add(req.URL, req.Title, req.ID, req.Artist)
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
+// handleCache reports cache usage on GET and evicts down to the budget on POST
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		pruned, err := s.manager.PruneCache()
+		if err != nil {
+			http.Error(w, "Prune failed", http.StatusInternalServerError)
+			return
+		}
+		resp["pruned"] = pruned
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	usage, err := s.manager.CacheUsage()
+	if err != nil {
+		log.Printf("Cache usage error: %v", err)
+		http.Error(w, "Failed to read cache", http.StatusInternalServerError)
+		return
+	}
+	resp["usage"] = usage
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleDiagnostics reports cache hit rates and sizes
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"youtube_cache": s.yt.CacheStats(),
+	}
+	if usage, err := s.manager.CacheUsage(); err == nil {
+		resp["download_cache"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
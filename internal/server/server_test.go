@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"kaboomer/internal/downloader"
+	"kaboomer/internal/manager"
+	"kaboomer/internal/player"
+	"kaboomer/internal/player/mpvtest"
+	"kaboomer/internal/playlists"
+	"kaboomer/internal/youtube"
+	"kaboomer/internal/ytdlptest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	ytdlptest.Main()
+	os.Exit(m.Run())
+}
+
+// newTestServer returns a server whose manager drives a fake mpv and a fake
+// yt-dlp. The download cache already holds entries, each with an empty file.
+func newTestServer(t *testing.T, entries ...downloader.Entry) (*Server, *ytdlptest.Fake) {
+	t.Helper()
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		entries[i].Path = filepath.Join(cacheDir, entries[i].ID+".m4a")
+		if err := os.WriteFile(entries[i].Path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "index.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := mpvtest.New(t)
+	p, err := player.Attach(fake.SocketPath)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	t.Cleanup(p.Stop)
+
+	ytdlp := ytdlptest.New(t, ytdlptest.Script{})
+	dl, err := downloader.New(ytdlp.Path, cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yt := youtube.New("", ytdlp.Path)
+	lists, err := playlists.New(filepath.Join(dir, "playlists"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(manager.New(p, dl, yt), yt, nil, lists, ""), ytdlp
+}
+
+// call runs one request through handler and returns the recorded response
+func call(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+// decode parses a JSON response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("bad response %q: %v", rec.Body, err)
+	}
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// itemStatus returns the status of the only queue item
+func itemStatus(s *Server) manager.TrackStatus {
+	queue := s.manager.GetQueue()
+	if len(queue) != 1 {
+		return ""
+	}
+	return queue[0].Status
+}
+
+func TestQueueRetry(t *testing.T) {
+	s, ytdlp := newTestServer(t)
+	ytdlp.Set(t, ytdlptest.Script{Stderr: "ERROR: [youtube] a1: Private video\n", Exit: 1})
+	s.manager.Add("https://www.youtube.com/watch?v=a1", "A", "a1", "")
+	eventually(t, "A to fail", func() bool { return itemStatus(s) == manager.StatusError })
+	queueID := s.manager.GetQueue()[0].QueueID
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"bad body", http.MethodPost, "{", http.StatusBadRequest},
+		{"unknown item", http.MethodPost, `{"queue_id":"nope"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := call(s.handleQueueRetry, tt.method, "/api/queue/retry", tt.body); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+	}
+
+	// An empty body retries everything that failed
+	rec := call(s.handleQueueRetry, http.MethodPost, "/api/queue/retry", "")
+	var resp map[string]int
+	decode(t, rec, &resp)
+	if rec.Code != http.StatusOK || resp["retried"] != 1 {
+		t.Fatalf("retry all = %d %v, want 1 retried", rec.Code, resp)
+	}
+	eventually(t, "A to fail again", func() bool { return itemStatus(s) == manager.StatusError })
+
+	ytdlp.Set(t, ytdlptest.Script{Download: &ytdlptest.Download{Size: 10}})
+	body := `{"queue_id":"` + queueID + `"}`
+	if rec := call(s.handleQueueRetry, http.MethodPost, "/api/queue/retry", body); rec.Code != http.StatusOK {
+		t.Fatalf("retry = %d %s", rec.Code, rec.Body)
+	}
+	eventually(t, "A to download", func() bool { return itemStatus(s) == manager.StatusReady })
+	if rec := call(s.handleQueueRetry, http.MethodPost, "/api/queue/retry", body); rec.Code != http.StatusConflict {
+		t.Errorf("retrying a ready item: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestControlShuffleSeed(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for _, body := range []string{
+		`{"action":"mode","mode":"shuffle","value":-1}`,
+		`{"action":"mode","mode":"shuffle","value":1.5}`,
+		`{"action":"mode","mode":"shuffle","value":1e30}`,
+	} {
+		if rec := call(s.handleControl, http.MethodPost, "/api/control", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", body, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if mode, _ := s.manager.GetMode(); mode != manager.ModeOff {
+		t.Fatalf("mode = %q after rejected seeds, want %q", mode, manager.ModeOff)
+	}
+
+	body := `{"action":"mode","mode":"shuffle","value":42}`
+	if rec := call(s.handleControl, http.MethodPost, "/api/control", body); rec.Code != http.StatusOK {
+		t.Fatalf("mode = %d %s", rec.Code, rec.Body)
+	}
+	if mode, seed := s.manager.GetMode(); mode != manager.ModeShuffle || seed != 42 {
+		t.Errorf("GetMode() = %q %d, want %q 42", mode, seed, manager.ModeShuffle)
+	}
+}
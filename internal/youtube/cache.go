@@ -0,0 +1,229 @@
+package youtube
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"kaboomer/internal/atomicfile"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Searches are kept long enough to page through and to make typing the
+	// same query again free; results on YouTube shift slowly
+	searchTTL         = time.Hour
+	maxCachedSearches = 64
+
+	// Video metadata hardly ever changes
+	metadataTTL     = 7 * 24 * time.Hour
+	maxCachedVideos = 2000
+)
+
+// CacheStats describes one cache for diagnostics
+type CacheStats struct {
+	Entries    int     `json:"entries"`
+	MaxEntries int     `json:"max_entries"`
+	TTL        float64 `json:"ttl_seconds"`
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+}
+
+// lru is a size and TTL bounded cache. The least recently used entry is
+// dropped when it is full; expired entries count as misses.
+type lru[V any] struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	max    int
+	items  map[string]*list.Element
+	order  *list.List // Of *lruEntry[V], most recently used first
+	hits   uint64
+	misses uint64
+}
+
+type lruEntry[V any] struct {
+	Key     string    `json:"key"`
+	Value   V         `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+func newLRU[V any](max int, ttl time.Duration) *lru[V] {
+	return &lru[V]{
+		ttl:   ttl,
+		max:   max,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns the value for key
+func (c *lru[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(key)
+}
+
+// getOrCreate returns the value for key, storing create() if it is missing.
+// ok reports a hit.
+func (c *lru[V]) getOrCreate(key string, create func() V) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.lookup(key); ok {
+		return value, true
+	}
+	value = create()
+	c.store(key, value, time.Now().Add(c.ttl))
+	return value, false
+}
+
+// put stores value under key
+func (c *lru[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, value, time.Now().Add(c.ttl))
+}
+
+// lookup counts a hit or miss. c.mu must be locked.
+func (c *lru[V]) lookup(key string) (V, bool) {
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return zero, false
+	}
+	entry := el.Value.(*lruEntry[V])
+	if time.Now().After(entry.Expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.misses++
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	c.hits++
+	return entry.Value, true
+}
+
+// store adds or replaces an entry, evicting the least recently used one when
+// full. c.mu must be locked.
+func (c *lru[V]) store(key string, value V, expires time.Time) {
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry[V])
+		entry.Value, entry.Expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{Key: key, Value: value, Expires: expires})
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).Key)
+	}
+}
+
+func (c *lru[V]) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:    c.order.Len(),
+		MaxEntries: c.max,
+		TTL:        c.ttl.Seconds(),
+		Hits:       c.hits,
+		Misses:     c.misses,
+	}
+}
+
+// entries returns the unexpired entries, least recently used first
+func (c *lru[V]) entries() []lruEntry[V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var entries []lruEntry[V]
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		if entry := el.Value.(*lruEntry[V]); now.Before(entry.Expires) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// load adds saved entries, least recently used first
+func (c *lru[V]) load(entries []lruEntry[V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, entry := range entries {
+		if now.Before(entry.Expires) {
+			c.store(entry.Key, entry.Value, entry.Expires)
+		}
+	}
+}
+
+// savedCache is the on-disk form of the service caches
+type savedCache struct {
+	Searches []lruEntry[*searchRun] `json:"searches"`
+	Videos   []lruEntry[Metadata]   `json:"videos"`
+}
+
+// CacheStats reports the search and metadata caches
+func (s *Service) CacheStats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"search":   s.searches.stats(),
+		"metadata": s.videos.stats(),
+	}
+}
+
+// LoadCache restores the caches saved at path and makes SaveCache write
+// there. A missing file is not an error.
+func (s *Service) LoadCache(path string) error {
+	s.cachePath = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read youtube cache: %w", err)
+	}
+
+	var saved savedCache
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse youtube cache: %w", err)
+	}
+	s.searches.load(saved.Searches)
+	s.videos.load(saved.Videos)
+	log.Printf("Loaded %d searches and %d videos from youtube cache", len(saved.Searches), len(saved.Videos))
+	return nil
+}
+
+// SaveCache writes the caches to the path given to LoadCache, if any
+func (s *Service) SaveCache() error {
+	if s.cachePath == "" {
+		return nil
+	}
+
+	saved := savedCache{Videos: s.videos.entries()}
+	for _, entry := range s.searches.entries() {
+		// Copy under the run's lock, a search may be filling it
+		entry.Value.mu.Lock()
+		run := &searchRun{
+			Results: entry.Value.Results,
+			Next:    entry.Value.Next,
+			Done:    entry.Value.Done,
+		}
+		entry.Value.mu.Unlock()
+		entry.Value = run
+		saved.Searches = append(saved.Searches, entry)
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicfile.Write(s.cachePath, data); err != nil {
+		return fmt.Errorf("failed to save youtube cache: %w", err)
+	}
+	return nil
+}
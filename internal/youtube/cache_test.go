@@ -0,0 +1,103 @@
+package youtube
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// keys returns the cached keys, least recently used first
+func keys[V any](c *lru[V]) []string {
+	var out []string
+	for _, entry := range c.entries() {
+		out = append(out, entry.Key)
+	}
+	return out
+}
+
+func TestLRUEviction(t *testing.T) {
+	c := newLRU[int](2, time.Hour)
+	c.put("a", 1)
+	c.put("b", 2)
+	if _, ok := c.get("a"); !ok { // a is now the most recently used
+		t.Fatal("a missing")
+	}
+	c.put("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b kept, want it evicted as least recently used")
+	}
+	if got, want := keys(c), []string{"a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+
+	// Replacing a value doesn't take a second slot
+	c.put("a", 10)
+	if v, ok := c.get("a"); !ok || v != 10 {
+		t.Errorf("a = %d, %v; want 10", v, ok)
+	}
+	if stats := c.stats(); stats.Entries != 2 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want 2 entries, 2 hits and 1 miss", stats)
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := newLRU[int](10, 50*time.Millisecond)
+	c.put("a", 1)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a missing before it expired")
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	c.put("b", 2)
+	if got := keys(c); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("unexpired keys = %v, want only b", got)
+	}
+	if _, ok := c.get("a"); ok {
+		t.Error("a returned after it expired")
+	}
+	if stats := c.stats(); stats.Entries != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want the expired entry dropped and counted as a miss", stats)
+	}
+
+	// getOrCreate keeps fresh entries and replaces expired ones
+	if v, ok := c.getOrCreate("b", func() int { return 3 }); !ok || v != 2 {
+		t.Errorf("getOrCreate(b) = %d, %v; want the fresh 2", v, ok)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if v, ok := c.getOrCreate("b", func() int { return 3 }); ok || v != 3 {
+		t.Errorf("getOrCreate(b) = %d, %v; want a new 3", v, ok)
+	}
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "youtube_cache.json")
+	s := New("", "")
+	if err := s.LoadCache(path); err != nil {
+		t.Fatal(err)
+	}
+	meta := Metadata{ID: "a1", Title: "Song"}
+	s.videos.put("a1", meta)
+	s.videos.store("old", Metadata{ID: "old"}, time.Now().Add(-time.Minute))
+	run, _ := s.searches.getOrCreate("video\x00q", func() *searchRun { return &searchRun{Next: 1} })
+	run.Results = []SearchResult{{ID: "a1"}}
+	run.Next = 21
+	if err := s.SaveCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := New("", "")
+	if err := s2.LoadCache(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s2.videos.get("a1"); !ok || got != meta {
+		t.Errorf("a1 = %+v, %v; want %+v", got, ok, meta)
+	}
+	if _, ok := s2.videos.get("old"); ok {
+		t.Error("expired entry survived a save")
+	}
+	if got, ok := s2.searches.get("video\x00q"); !ok || got.Next != 21 || len(got.Results) != 1 {
+		t.Errorf("search = %+v, %v; want the saved run", got, ok)
+	}
+}
@@ -0,0 +1,83 @@
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxExpand caps how many entries one Expand call returns, since mixes and
+// big channels are effectively endless
+const MaxExpand = 1000
+
+// ErrNotHTTP is returned for a URL that isn't http or https. yt-dlp would
+// take anything else for an option, a local file or another extractor.
+var ErrNotHTTP = errors.New("not an http or https URL")
+
+// CheckURL returns ErrNotHTTP unless rawURL is an http or https URL
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrNotHTTP
+	}
+	return nil
+}
+
+// Expand lists the videos behind a playlist, album, mix or channel URL.
+// offset skips that many entries and limit caps the result (0 for MaxExpand).
+// Private and deleted videos are left out.
+func (s *Service) Expand(ctx context.Context, rawURL string, offset, limit int) ([]SearchResult, error) {
+	if err := CheckURL(rawURL); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > MaxExpand {
+		limit = MaxExpand
+	}
+
+	args := []string{
+		"--dump-json",
+		"--flat-playlist",
+		"--no-warnings",
+		"--playlist-start", strconv.Itoa(offset + 1),
+		"--playlist-end", strconv.Itoa(offset + limit),
+		"--", channelUploads(rawURL),
+	}
+	output, err := s.run(ctx, s.timeouts().Expand, args)
+	if err != nil {
+		return nil, fmt.Errorf("yt-dlp playlist expansion failed: %w", err)
+	}
+
+	var results []SearchResult
+	for _, res := range parseEntries(output, TypeVideo) {
+		if res.Title == "[Private video]" || res.Title == "[Deleted video]" {
+			continue
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// channelUploads points a bare YouTube channel URL at its uploads. The
+// channel home page lists tabs (Videos, Shorts, ...) rather than videos.
+func channelUploads(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.Contains(u.Host, "youtube.com") {
+		return rawURL
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && strings.HasPrefix(parts[0], "@"):
+	case len(parts) == 2 && (parts[0] == "channel" || parts[0] == "c" || parts[0] == "user"):
+	default:
+		return rawURL
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/videos"
+	return u.String()
+}
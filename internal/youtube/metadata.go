@@ -0,0 +1,72 @@
+package youtube
+
+import (
+	"context"
+	"fmt"
+)
+
+// Metadata is what we know about one video without downloading it
+type Metadata struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Uploader  string `json:"uploader"`
+	Duration  int    `json:"duration"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// rememberVideos caches the metadata of video search results, so queueing
+// one of them by URL later needs no lookup
+func (s *Service) rememberVideos(results []SearchResult) {
+	for _, res := range results {
+		if res.ID == "" || (res.Type != TypeVideo && res.Type != TypeSong) {
+			continue
+		}
+		s.videos.put(res.ID, Metadata{
+			ID:        res.ID,
+			Title:     res.Title,
+			Uploader:  res.Uploader,
+			Duration:  res.Duration,
+			Thumbnail: res.Thumbnail,
+		})
+	}
+}
+
+// Metadata returns the title, uploader, duration and thumbnail of a video,
+// from the cache when possible
+func (s *Service) Metadata(ctx context.Context, videoURL string) (Metadata, error) {
+	id := s.ExtractID(videoURL)
+	if id != "" {
+		if meta, ok := s.videos.get(id); ok {
+			return meta, nil
+		}
+	}
+
+	args := []string{
+		"--dump-json",
+		"--no-playlist",
+		"--no-warnings",
+		"--", videoURL, // Never taken for an option, whatever the user sent
+	}
+	output, err := s.run(ctx, s.timeouts().Search, args)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("yt-dlp metadata lookup failed: %w", err)
+	}
+
+	results := parseEntries(output, TypeVideo)
+	if len(results) == 0 {
+		return Metadata{}, fmt.Errorf("yt-dlp returned no metadata for %s", videoURL)
+	}
+
+	res := results[0]
+	meta := Metadata{
+		ID:        res.ID,
+		Title:     res.Title,
+		Uploader:  res.Uploader,
+		Duration:  res.Duration,
+		Thumbnail: res.Thumbnail,
+	}
+	if meta.ID != "" {
+		s.videos.put(meta.ID, meta)
+	}
+	return meta, nil
+}
@@ -0,0 +1,134 @@
+package youtube
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 50
+	// MaxSearchResults is how deep Search will page into one query
+	MaxSearchResults = 500
+
+	// searchChunk is how many results one yt-dlp run fetches, about one page
+	// of YouTube's own results. Small pages are served from the chunk.
+	searchChunk = 20
+	// songChunk is searchChunk for song searches. Each song is a full
+	// extraction, a few seconds on a Pi, so a run must stay well inside the
+	// search timeout.
+	songChunk = 5
+)
+
+// SearchOptions selects what and how much Search returns
+type SearchOptions struct {
+	Type   SearchType
+	Offset int // Results to skip
+	Size   int // Results to return, DefaultPageSize if 0
+}
+
+// Search returns one page of results for query. Results are fetched from
+// yt-dlp in chunks and cached (see cache.go), so repeating a query or paging
+// through it ("load more") only runs yt-dlp for results not fetched yet.
+// A short or empty page means there are no more results, except that a song
+// search running out of time returns what it got so far; asking again
+// carries on from there. Cancelling ctx kills the yt-dlp run, the results
+// fetched so far stay cached.
+func (s *Service) Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, error) {
+	if opts.Type == "" {
+		opts.Type = TypeVideo
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	size = min(size, MaxPageSize)
+	offset := max(opts.Offset, 0)
+	end := min(offset+size, MaxSearchResults)
+	if offset >= end {
+		return []SearchResult{}, nil
+	}
+
+	run, _ := s.searches.getOrCreate(string(opts.Type)+"\x00"+query, func() *searchRun {
+		return &searchRun{Next: 1}
+	})
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	chunk := searchChunk
+	if opts.Type == TypeSong {
+		chunk = songChunk
+	}
+	for !run.Done && run.Next <= end {
+		// Fetch whole chunks, the next page is likely to be asked for soon
+		to := min((end+chunk-1)/chunk*chunk, MaxSearchResults)
+		if opts.Type == TypeSong {
+			to = min(to, run.Next+chunk-1) // One chunk per run, each with its own timeout
+		}
+		results, partial, err := s.runSearch(ctx, query, opts.Type, run.Next, to)
+		if err != nil && len(results) == 0 {
+			return nil, err
+		}
+		fetched := len(results)
+		if opts.Type == TypeSong && !isURL(query) {
+			results = run.unseen(results)
+		}
+		run.Results = append(run.Results, results...)
+		s.rememberVideos(results)
+
+		switch {
+		case isURL(query), fetched == 0:
+			run.Done = true
+		case opts.Type != TypeSong && fetched < to-run.Next+1:
+			// Song searches drop unavailable entries, so only an empty
+			// chunk tells they ran out
+			run.Done = true
+		}
+		if partial {
+			// How far yt-dlp got past the entries it skipped isn't known, so
+			// the chunk only counts as far as what came back. The rest is
+			// fetched again, results already there are dropped by unseen.
+			run.Next += fetched
+		} else {
+			run.Next = to + 1
+		}
+		if run.Next > MaxSearchResults {
+			run.Done = true
+		}
+		if err != nil {
+			// Out of time partway, serve what came back
+			break
+		}
+	}
+
+	if offset >= len(run.Results) {
+		return []SearchResult{}, nil
+	}
+	page := run.Results[offset:min(end, len(run.Results))]
+	return append([]SearchResult(nil), page...), nil
+}
+
+// searchRun is what has been fetched so far for one query
+type searchRun struct {
+	mu      sync.Mutex     // Held while fetching, so concurrent pages share one yt-dlp run
+	Results []SearchResult `json:"results"`
+	Next    int            `json:"next"` // 1-based position of the first result not fetched yet
+	Done    bool           `json:"done"` // Nothing more to fetch
+}
+
+// unseen drops the results fetched already. A song search fetching again
+// after a partial chunk starts among them.
+func (r *searchRun) unseen(results []SearchResult) []SearchResult {
+	seen := make(map[string]bool, len(r.Results))
+	for _, res := range r.Results {
+		seen[res.ID] = true
+	}
+	var out []SearchResult
+	for _, res := range results {
+		if !seen[res.ID] {
+			seen[res.ID] = true
+			out = append(out, res)
+		}
+	}
+	return out
+}
@@ -3,17 +3,58 @@ package youtube
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Service struct {
 	cookiesPath string
 	ytDlpPath   string
+	mu          sync.Mutex
+	timeout     Timeouts
+	searches    *lru[*searchRun] // By type and query
+	videos      *lru[Metadata]   // By video id
+	cachePath   string           // Where SaveCache persists the caches, empty if not persisted
+}
+
+// SearchType selects what Search looks for
+type SearchType string
+
+const (
+	TypeVideo    SearchType = "video"    // Plain YouTube search
+	TypeSong     SearchType = "song"     // YouTube Music songs
+	TypeAlbum    SearchType = "album"    // YouTube Music albums
+	TypeArtist   SearchType = "artist"   // YouTube Music artists
+	TypePlaylist SearchType = "playlist" // YouTube Music community playlists
+)
+
+// musicSections maps music search types to sections of the YouTube Music search page
+var musicSections = map[SearchType]string{
+	TypeSong:     "songs",
+	TypeAlbum:    "albums",
+	TypeArtist:   "artists",
+	TypePlaylist: "community playlists",
+}
+
+// ParseSearchType checks a search type from a request. Empty means TypeVideo.
+func ParseSearchType(s string) (SearchType, error) {
+	t := SearchType(s)
+	if t == "" || t == TypeVideo {
+		return TypeVideo, nil
+	}
+	if _, ok := musicSections[t]; !ok {
+		return "", fmt.Errorf("unknown search type %q", s)
+	}
+	return t, nil
 }
 
 type SearchResult struct {
@@ -23,16 +64,28 @@ type SearchResult struct {
 	Duration  int    `json:"duration"` // Duration in seconds (sometimes float, but int is easier)
 	URL       string `json:"url"`
 	Thumbnail string `json:"thumbnail"`
+
+	Type   SearchType `json:"type,omitempty"`
+	Artist string     `json:"artist,omitempty"`
+	Album  string     `json:"album,omitempty"`
+	Track  string     `json:"track,omitempty"`
 }
 
 // yt-dlp JSON output structure (subset)
 type ytdlpEntry struct {
-	ID       string      `json:"id"`
-	Title    string      `json:"title"`
-	Uploader string      `json:"uploader"`
-	Duration interface{} `json:"duration"` // Can be float or null
-	Url      string      `json:"url"`      // Direct URL or page URL
-	Webpage  string      `json:"webpage_url"`
+	ID         string      `json:"id"`
+	Title      string      `json:"title"`
+	Uploader   string      `json:"uploader"`
+	Channel    string      `json:"channel"`
+	Duration   interface{} `json:"duration"` // Can be float or null
+	Url        string      `json:"url"`      // Direct URL or page URL
+	Webpage    string      `json:"webpage_url"`
+	Track      string      `json:"track"`  // Music metadata, only in full extraction
+	Artist     string      `json:"artist"` // Comma separated if several
+	Album      string      `json:"album"`
+	Thumbnails []struct {
+		URL string `json:"url"`
+	} `json:"thumbnails"` // Smallest first
 }
 
 func New(cookiesPath, ytDlpPath string) *Service {
@@ -42,7 +95,64 @@ func New(cookiesPath, ytDlpPath string) *Service {
 	return &Service{
 		cookiesPath: cookiesPath,
 		ytDlpPath:   ytDlpPath,
+		searches:    newLRU[*searchRun](maxCachedSearches, searchTTL),
+		videos:      newLRU[Metadata](maxCachedVideos, metadataTTL),
+		timeout:     DefaultTimeouts,
+	}
+}
+
+// Timeouts bound how long each kind of yt-dlp call may run. Zero means no limit.
+type Timeouts struct {
+	Search time.Duration // Searches and metadata lookups
+	Expand time.Duration // Playlist expansion, which pages through the whole list
+}
+
+var DefaultTimeouts = Timeouts{
+	Search: 30 * time.Second,
+	Expand: 2 * time.Minute,
+}
+
+// SetTimeouts changes the yt-dlp deadlines
+func (s *Service) SetTimeouts(t Timeouts) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = t
+}
+
+func (s *Service) timeouts() Timeouts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timeout
+}
+
+// run runs yt-dlp and returns its stdout, which may be partial on error.
+// The process is killed when ctx is done or timeout passes.
+func (s *Service) run(ctx context.Context, timeout time.Duration, args []string) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if s.cookiesPath != "" {
+		if _, err := os.Stat(s.cookiesPath); err == nil {
+			// In front, args may end with -- and a URL
+			args = append([]string{"--cookies", s.cookiesPath}, args...)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, s.ytDlpPath, args...)
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cmd.Output()
+	if err != nil {
+		switch ctxErr := ctx.Err(); {
+		case errors.Is(ctxErr, context.DeadlineExceeded):
+			return output, fmt.Errorf("timed out after %s: %w", timeout, ctxErr)
+		case ctxErr != nil:
+			return output, fmt.Errorf("cancelled: %w", ctxErr)
+		}
 	}
+	return output, err
 }
 
 // ExtractID tries to find the video ID from a URL
@@ -66,10 +176,25 @@ func (s *Service) ExtractID(videoURL string) string {
 	return ""
 }
 
-// Search performs a search using yt-dlp
-func (s *Service) Search(query string) ([]SearchResult, error) {
+// WatchURL returns the YouTube page of a video id
+func WatchURL(id string) string {
+	return "https://www.youtube.com/watch?v=" + id
+}
+
+// isURL reports whether a search query is a link to resolve rather than terms to search for
+func isURL(query string) bool {
+	return len(query) > 4 && query[:4] == "http"
+}
+
+// runSearch runs one yt-dlp search for results start to end (1-based,
+// inclusive). Queries starting with http are resolved as URLs whatever the
+// type, and return everything at once. Partial is set when a song search
+// skipped entries it failed to extract, so fewer positions were returned
+// than yt-dlp went through. A song search that times out returns the
+// entries it got before, as partial, along with the error.
+func (s *Service) runSearch(ctx context.Context, query string, t SearchType, start, end int) ([]SearchResult, bool, error) {
 	var args []string
-	if len(query) > 4 && query[:4] == "http" {
+	if isURL(query) {
 		// Direct URL
 		args = []string{
 			query,
@@ -77,33 +202,58 @@ func (s *Service) Search(query string) ([]SearchResult, error) {
 			"--flat-playlist",
 			"--no-warnings",
 		}
+	} else if section, ok := musicSections[t]; ok {
+		// YouTube Music search page, one section of it
+		musicURL := "https://music.youtube.com/search?q=" + url.QueryEscape(query) + "#" + url.PathEscape(section)
+		args = []string{
+			musicURL,
+			"--dump-json",
+			"--no-warnings",
+			"--playlist-start", strconv.Itoa(start),
+			"--playlist-end", strconv.Itoa(end),
+		}
+		if t == TypeSong {
+			// Flat entries only carry the title, artist and album need a full
+			// extraction. Slower, and one unavailable song shouldn't fail the rest.
+			args = append(args, "--ignore-errors")
+		} else {
+			args = append(args, "--flat-playlist")
+		}
 	} else {
 		// Search
-		// ytsearch10:query -> return top 10 results
-		searchQuery := fmt.Sprintf("ytsearch10:%s", query)
+		// ytsearchN:query -> the top N results, of which we only extract start to end
+		searchQuery := fmt.Sprintf("ytsearch%d:%s", end, query)
 		args = []string{
 			searchQuery,
 			"--dump-json",
 			"--flat-playlist",
 			"--no-warnings",
+			"--playlist-start", strconv.Itoa(start),
+			"--playlist-end", strconv.Itoa(end),
 		}
 	}
 
-	if s.cookiesPath != "" {
-		if _, err := os.Stat(s.cookiesPath); err == nil {
-			args = append(args, "--cookies", s.cookiesPath)
+	output, err := s.run(ctx, s.timeouts().Search, args)
+	if err != nil {
+		// Song searches carry on past unavailable entries and exit with an
+		// error. The caller going away fails them like any other search.
+		if t != TypeSong || len(output) == 0 || ctx.Err() != nil {
+			return nil, false, fmt.Errorf("yt-dlp search failed: %w", err)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// A line cut off by the kill is malformed, parseEntries skips it
+			return parseEntries(output, t), true, fmt.Errorf("yt-dlp search failed: %w", err)
 		}
 	}
 
-	cmd := exec.Command(s.ytDlpPath, args...)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("yt-dlp search failed: %w", err)
-	}
+	return parseEntries(output, t), err != nil, nil
+}
 
-	// Output is line-delimited JSON
+// parseEntries converts yt-dlp's line-delimited JSON to search results of type t
+func parseEntries(output []byte, t SearchType) []SearchResult {
 	var results []SearchResult
 	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		var entry ytdlpEntry
@@ -123,24 +273,47 @@ func (s *Service) Search(query string) ([]SearchResult, error) {
 		}
 		// If still empty and we have ID, construct it
 		if url == "" && entry.ID != "" {
-			url = "https://www.youtube.com/watch?v=" + entry.ID
+			url = WatchURL(entry.ID)
 		}
 
-		// Construct a thumbnail URL if possible
+		// Construct a thumbnail URL if possible. Album, artist and playlist ids
+		// aren't video ids, those come with their own thumbnails.
 		thumb := ""
-		if entry.ID != "" {
+		if n := len(entry.Thumbnails); n > 0 {
+			thumb = entry.Thumbnails[n-1].URL
+		}
+		if entry.ID != "" && (t == TypeVideo || t == TypeSong) {
 			thumb = fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", entry.ID)
 		}
 
+		// Flat playlist entries often only carry the channel
+		uploader := entry.Uploader
+		if uploader == "" {
+			uploader = entry.Channel
+		}
+
+		// Auto-generated music channels are named "Artist - Topic"
+		artist := entry.Artist
+		if artist == "" {
+			artist = strings.TrimSuffix(uploader, " - Topic")
+		}
+		if t == TypeArtist {
+			artist = entry.Title
+		}
+
 		results = append(results, SearchResult{
 			ID:        entry.ID,
 			Title:     entry.Title,
-			Uploader:  entry.Uploader,
+			Uploader:  uploader,
 			Duration:  duration,
 			URL:       url,
 			Thumbnail: thumb,
+			Type:      t,
+			Artist:    artist,
+			Album:     entry.Album,
+			Track:     entry.Track,
 		})
 	}
 
-	return results, nil
+	return results
 }
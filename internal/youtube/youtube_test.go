@@ -0,0 +1,404 @@
+package youtube
+
+import (
+	"context"
+	"errors"
+	"kaboomer/internal/ytdlptest"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	ytdlptest.Main()
+	os.Exit(m.Run())
+}
+
+func TestExtractID(t *testing.T) {
+	s := New("", "")
+	tests := []struct {
+		url, want string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtube.com/watch?list=PL123&v=dQw4w9WgXcQ&t=42", "dQw4w9WgXcQ"},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/playlist?list=PL123", ""},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", ""},
+		{"file:///music/song.mp3", ""},
+		{"not a url", ""},
+	}
+	for _, tt := range tests {
+		if got := s.ExtractID(tt.url); got != tt.want {
+			t.Errorf("ExtractID(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// lines joins fixture lines the way yt-dlp prints --dump-json output
+func lines(l ...string) string {
+	return strings.Join(l, "\n") + "\n"
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		typ    SearchType
+		want   []SearchResult
+	}{
+		{
+			name:   "url fallbacks",
+			output: lines(`{"id":"a1","title":"Has URL","url":"https://www.youtube.com/watch?v=a1","uploader":"Up","duration":61.7}`, `{"id":"b2","title":"Has page","webpage_url":"https://www.youtube.com/watch?v=b2","duration":null}`, `{"id":"c3","title":"Id only"}`),
+			typ:    TypeVideo,
+			want: []SearchResult{
+				{ID: "a1", Title: "Has URL", Uploader: "Up", Duration: 61, URL: "https://www.youtube.com/watch?v=a1", Thumbnail: "https://i.ytimg.com/vi/a1/hqdefault.jpg", Type: TypeVideo, Artist: "Up"},
+				{ID: "b2", Title: "Has page", URL: "https://www.youtube.com/watch?v=b2", Thumbnail: "https://i.ytimg.com/vi/b2/hqdefault.jpg", Type: TypeVideo},
+				{ID: "c3", Title: "Id only", URL: "https://www.youtube.com/watch?v=c3", Thumbnail: "https://i.ytimg.com/vi/c3/hqdefault.jpg", Type: TypeVideo},
+			},
+		},
+		{
+			name:   "malformed lines are skipped",
+			output: lines(`WARNING: not json`, `{"id":"a1","title":"Fine"`, `{"id":"b2","title":"Fine","url":"u"}`),
+			typ:    TypeVideo,
+			want: []SearchResult{
+				{ID: "b2", Title: "Fine", URL: "u", Thumbnail: "https://i.ytimg.com/vi/b2/hqdefault.jpg", Type: TypeVideo},
+			},
+		},
+		{
+			name:   "channel and topic artist",
+			output: lines(`{"id":"s1","title":"Song","channel":"Band - Topic","track":"Song","album":"Record","url":"u"}`),
+			typ:    TypeSong,
+			want: []SearchResult{
+				{ID: "s1", Title: "Song", Uploader: "Band - Topic", URL: "u", Thumbnail: "https://i.ytimg.com/vi/s1/hqdefault.jpg", Type: TypeSong, Artist: "Band", Album: "Record", Track: "Song"},
+			},
+		},
+		{
+			name:   "albums keep their own thumbnails",
+			output: lines(`{"id":"MPREb_x","title":"Record","channel":"Band","url":"u","thumbnails":[{"url":"small"},{"url":"big"}]}`),
+			typ:    TypeAlbum,
+			want: []SearchResult{
+				{ID: "MPREb_x", Title: "Record", Uploader: "Band", URL: "u", Thumbnail: "big", Type: TypeAlbum, Artist: "Band"},
+			},
+		},
+		{
+			name:   "no results",
+			output: "",
+			typ:    TypeVideo,
+			want:   []SearchResult{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := ytdlptest.New(t, ytdlptest.Script{Stdout: tt.output})
+			s := New("", fake.Path)
+
+			got, err := s.Search(context.Background(), "query", SearchOptions{Type: tt.typ})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		opts  SearchOptions
+		want  []string // Must all be in the command line
+		not   []string // Must not be
+	}{
+		{
+			name:  "video search",
+			query: "some band",
+			opts:  SearchOptions{Offset: 20, Size: 10},
+			want:  []string{"ytsearch40:some band", "--flat-playlist", "--playlist-start", "1", "--playlist-end", "40"},
+		},
+		{
+			name:  "songs need full extraction",
+			query: "some band",
+			opts:  SearchOptions{Type: TypeSong},
+			want:  []string{"https://music.youtube.com/search?q=some+band#songs", "--ignore-errors", "--playlist-end", "5"},
+			not:   []string{"--flat-playlist"},
+		},
+		{
+			name:  "albums",
+			query: "some band",
+			opts:  SearchOptions{Type: TypeAlbum},
+			want:  []string{"https://music.youtube.com/search?q=some+band#albums", "--flat-playlist"},
+		},
+		{
+			name:  "urls are resolved",
+			query: "https://www.youtube.com/watch?v=a1",
+			opts:  SearchOptions{Type: TypeSong},
+			want:  []string{"https://www.youtube.com/watch?v=a1", "--flat-playlist"},
+			not:   []string{"--playlist-start"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := ytdlptest.New(t, ytdlptest.Script{})
+			s := New("", fake.Path)
+			if _, err := s.Search(context.Background(), tt.query, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := fake.Calls(t)
+			if len(calls) != 1 {
+				t.Fatalf("yt-dlp ran %d times, want 1", len(calls))
+			}
+			for _, arg := range tt.want {
+				if !slices.Contains(calls[0], arg) {
+					t.Errorf("args %q lack %q", calls[0], arg)
+				}
+			}
+			for _, arg := range tt.not {
+				if slices.Contains(calls[0], arg) {
+					t.Errorf("args %q contain %q", calls[0], arg)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchPagesFromCache(t *testing.T) {
+	var fixture []string
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		fixture = append(fixture, `{"id":"`+id+`","title":"`+id+`","url":"u"}`)
+	}
+	fake := ytdlptest.New(t, ytdlptest.Script{Stdout: lines(fixture...)})
+	s := New("", fake.Path)
+
+	var ids []string
+	for offset := 0; offset < 6; offset += 2 {
+		page, err := s.Search(context.Background(), "q", SearchOptions{Offset: offset, Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range page {
+			ids = append(ids, res.ID)
+		}
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(ids, want) {
+		t.Errorf("paged ids = %v, want %v", ids, want)
+	}
+	// A short chunk means the results ran out, no need to ask again
+	if n := len(fake.Calls(t)); n != 1 {
+		t.Errorf("yt-dlp ran %d times, want 1", n)
+	}
+	if stats := s.CacheStats()["search"]; stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("search cache stats = %+v, want 2 hits and 1 miss", stats)
+	}
+}
+
+func TestSearchFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  ytdlptest.Script
+		typ     SearchType
+		wantErr error // nil for any error
+		wantN   int   // Results when no error is expected
+		ok      bool
+	}{
+		{
+			name:   "exit status",
+			script: ytdlptest.Script{Stderr: "ERROR: network down\n", Exit: 1},
+		},
+		{
+			name:   "songs keep partial output",
+			script: ytdlptest.Script{Stdout: lines(`{"id":"s1","title":"Song","url":"u"}`), Stderr: "ERROR: [youtube] s2: Video unavailable\n", Exit: 1},
+			typ:    TypeSong,
+			ok:     true,
+			wantN:  1,
+		},
+		{
+			name:    "hang",
+			script:  ytdlptest.Script{Hang: true},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:   "songs timed out partway",
+			script: ytdlptest.Script{Stdout: lines(`{"id":"s1","title":"Song","url":"u"}`, `{"id":"s2","ti`), Hang: true},
+			typ:    TypeSong,
+			ok:     true,
+			wantN:  1,
+		},
+		{
+			name:    "songs timed out with nothing",
+			script:  ytdlptest.Script{Hang: true},
+			typ:     TypeSong,
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := ytdlptest.New(t, tt.script)
+			s := New("", fake.Path)
+			s.SetTimeouts(Timeouts{Search: 200 * time.Millisecond})
+
+			got, err := s.Search(context.Background(), "q", SearchOptions{Type: tt.typ})
+			if tt.ok {
+				if err != nil || len(got) != tt.wantN {
+					t.Errorf("Search() = %d results, %v; want %d results", len(got), err, tt.wantN)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Search() succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Search() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSearchPartialSongs(t *testing.T) {
+	song := func(id string) string { return `{"id":"` + id + `","title":"` + id + `","url":"u"}` }
+	// s2 is unavailable, yt-dlp skips it and fails at the end
+	fake := ytdlptest.New(t, ytdlptest.Script{Stdout: lines(song("s1"), song("s3")), Stderr: "ERROR: [youtube] s2: Video unavailable\n", Exit: 1})
+	s := New("", fake.Path)
+
+	search := func(offset int) []string {
+		t.Helper()
+		page, err := s.Search(context.Background(), "q", SearchOptions{Type: TypeSong, Offset: offset, Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, res := range page {
+			ids = append(ids, res.ID)
+		}
+		return ids
+	}
+
+	if got, want := search(0), []string{"s1", "s3"}; !slices.Equal(got, want) {
+		t.Errorf("first page = %v, want %v", got, want)
+	}
+	// Only two positions of the chunk count as fetched, the next page starts
+	// over from the third and drops s3, which it has already
+	fake.Set(t, ytdlptest.Script{Stdout: lines(song("s3"), song("s4"), song("s5"))})
+	if got, want := search(2), []string{"s4", "s5"}; !slices.Equal(got, want) {
+		t.Errorf("second page = %v, want %v", got, want)
+	}
+
+	calls := fake.Calls(t)
+	if len(calls) != 2 {
+		t.Fatalf("yt-dlp ran %d times, want 2", len(calls))
+	}
+	if i := slices.Index(calls[1], "--playlist-start"); i == -1 || calls[1][i+1] != "3" {
+		t.Errorf("second run args = %v, want --playlist-start 3", calls[1])
+	}
+}
+
+func TestSearchSongsTimeout(t *testing.T) {
+	song := func(id string) string { return `{"id":"` + id + `","title":"` + id + `","url":"u"}` }
+	fake := ytdlptest.New(t, ytdlptest.Script{Stdout: lines(song("s1")), Hang: true})
+	s := New("", fake.Path)
+	s.SetTimeouts(Timeouts{Search: 200 * time.Millisecond})
+
+	search := func() []string {
+		t.Helper()
+		page, err := s.Search(context.Background(), "q", SearchOptions{Type: TypeSong, Size: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, res := range page {
+			ids = append(ids, res.ID)
+		}
+		return ids
+	}
+
+	if got, want := search(), []string{"s1"}; !slices.Equal(got, want) {
+		t.Errorf("timed out page = %v, want %v", got, want)
+	}
+	// Asking again carries on after s1
+	fake.Set(t, ytdlptest.Script{Stdout: lines(song("s2"), song("s3"))})
+	if got, want := search(), []string{"s1", "s2", "s3"}; !slices.Equal(got, want) {
+		t.Errorf("page after the timeout = %v, want %v", got, want)
+	}
+	calls := fake.Calls(t)
+	if len(calls) != 2 {
+		t.Fatalf("yt-dlp ran %d times, want 2", len(calls))
+	}
+	if i := slices.Index(calls[1], "--playlist-start"); i == -1 || calls[1][i+1] != "2" {
+		t.Errorf("second run args = %v, want --playlist-start 2", calls[1])
+	}
+}
+
+func TestExpand(t *testing.T) {
+	fake := ytdlptest.New(t, ytdlptest.Script{Stdout: lines(
+		`{"id":"a1","title":"Song","url":"u"}`,
+		`{"id":"a2","title":"[Private video]","url":"u"}`,
+	)})
+	s := New("", fake.Path)
+
+	got, err := s.Expand(context.Background(), "https://www.youtube.com/@band", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "a1" {
+		t.Errorf("Expand() = %+v, want only a1", got)
+	}
+	calls := fake.Calls(t)
+	if len(calls) != 1 || !slices.Equal(calls[0][len(calls[0])-2:], []string{"--", "https://www.youtube.com/@band/videos"}) {
+		t.Errorf("args = %q, want the channel uploads after --", calls)
+	}
+
+	for _, bad := range []string{"--exec=touch /tmp/x", "-o/tmp/x", "file:///etc/passwd", "ytsearch:q", "https://"} {
+		if _, err := s.Expand(context.Background(), bad, 0, 0); !errors.Is(err, ErrNotHTTP) {
+			t.Errorf("Expand(%q) error = %v, want ErrNotHTTP", bad, err)
+		}
+	}
+	if n := len(fake.Calls(t)); n != 1 {
+		t.Errorf("yt-dlp ran %d times for refused URLs", n-1)
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	fake := ytdlptest.New(t, ytdlptest.Script{Hang: true})
+	s := New("", fake.Path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := s.Search(ctx, "q", SearchOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Search() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Search() took %s after cancel", elapsed)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	fake := ytdlptest.New(t, ytdlptest.Script{
+		Stdout: lines(`{"id":"a1","title":"Song","uploader":"Band","duration":200,"webpage_url":"https://www.youtube.com/watch?v=a1"}`),
+	})
+	s := New("", fake.Path)
+
+	for range 2 {
+		meta, err := s.Metadata(context.Background(), "https://youtu.be/a1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Metadata{ID: "a1", Title: "Song", Uploader: "Band", Duration: 200, Thumbnail: "https://i.ytimg.com/vi/a1/hqdefault.jpg"}
+		if meta != want {
+			t.Errorf("Metadata() = %+v, want %+v", meta, want)
+		}
+	}
+	if n := len(fake.Calls(t)); n != 1 {
+		t.Errorf("yt-dlp ran %d times, want 1 (second lookup cached)", n)
+	}
+}
@@ -0,0 +1,239 @@
+// Package ytdlptest provides a scripted stand-in for yt-dlp, so code that
+// shells out to it can be tested without the network.
+//
+// It uses the helper process pattern: the test binary itself plays yt-dlp.
+// A test package calls Main first thing in TestMain, then each test installs
+// a Fake and hands Fake.Path to the code under test as the yt-dlp path:
+//
+//	func TestMain(m *testing.M) {
+//		ytdlptest.Main()
+//		os.Exit(m.Run())
+//	}
+//
+//	fake := ytdlptest.New(t, ytdlptest.Script{Stdout: fixture})
+//	svc := youtube.New("", fake.Path)
+//
+// The Script tells every run of the fake what to print, whether to simulate
+// a download, fail or hang. Fakes use t.Setenv, so tests using them can't
+// run in parallel.
+package ytdlptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envScript points a fake run at its script. It is only set in the
+// environment of processes started by a test with a Fake installed.
+const envScript = "KABOOMER_FAKE_YTDLP"
+
+// Script is what one fake yt-dlp run does, in this order: print Stdout and
+// Stderr, simulate Download, then hang or exit with Exit.
+type Script struct {
+	Stdout   string    // E.g. --dump-json fixture lines
+	Stderr   string    // E.g. "ERROR: [youtube] abc: Private video"
+	Download *Download // Simulate a download, nil for none
+	Hang     bool      // Block until killed instead of exiting
+	Exit     int       // Exit status
+}
+
+// Download simulates yt-dlp fetching a file. It honours -o, --progress-template
+// and --print after_move: from the command line.
+type Download struct {
+	Ext      string  // Extension substituted for %(ext)s, m4a if empty
+	Size     int     // Bytes written to the file
+	Duration float64 // Substituted for %(duration)s, NA if 0
+	Updates  int     // Progress lines printed, spread evenly up to Size
+}
+
+// Fake is an installed stand-in yt-dlp
+type Fake struct {
+	Path string // Executable to use as the yt-dlp path
+
+	dir string
+}
+
+// New installs a fake yt-dlp running script. It replaces any fake installed
+// earlier in the same test.
+func New(t testing.TB, script Script) *Fake {
+	t.Helper()
+
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatalf("ytdlptest: %v", err)
+	}
+	f := &Fake{Path: path, dir: t.TempDir()}
+	f.Set(t, script)
+	t.Setenv(envScript, f.dir)
+	return f
+}
+
+// Set changes the script for the following runs
+func (f *Fake) Set(t testing.TB, script Script) {
+	t.Helper()
+	data, err := json.Marshal(script)
+	if err != nil {
+		t.Fatalf("ytdlptest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(f.dir, "script.json"), data, 0644); err != nil {
+		t.Fatalf("ytdlptest: %v", err)
+	}
+}
+
+// Calls returns the arguments of every run so far, oldest first
+func (f *Fake) Calls(t testing.TB) [][]string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(f.dir, "calls.jsonl"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("ytdlptest: %v", err)
+	}
+
+	var calls [][]string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var args []string
+		if err := json.Unmarshal([]byte(line), &args); err != nil {
+			t.Fatalf("ytdlptest: bad call record %q: %v", line, err)
+		}
+		calls = append(calls, args)
+	}
+	return calls
+}
+
+// Main runs the fake and exits if this process was started as one. Otherwise
+// it returns at once. Call it at the top of TestMain.
+func Main() {
+	dir := os.Getenv(envScript)
+	if dir == "" {
+		return
+	}
+	if err := run(dir, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ytdlptest: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+func run(dir string, args []string) error {
+	if err := record(dir, args); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "script.json"))
+	if err != nil {
+		return err
+	}
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return err
+	}
+
+	os.Stdout.WriteString(script.Stdout)
+	os.Stderr.WriteString(script.Stderr)
+	if script.Download != nil {
+		if err := download(script.Download, args); err != nil {
+			return err
+		}
+	}
+	if script.Hang {
+		time.Sleep(time.Hour) // Until the test's context kills us
+	}
+	os.Exit(script.Exit)
+	return nil
+}
+
+// record appends the arguments of this run to the calls file
+func record(dir string, args []string) error {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "calls.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// download writes the file named by -o, printing progress and the final path
+// with the templates given on the command line
+func download(d *Download, args []string) error {
+	output := flagValue(args, "-o")
+	if output == "" {
+		return fmt.Errorf("download without -o")
+	}
+	ext := d.Ext
+	if ext == "" {
+		ext = "m4a"
+	}
+	path := expand(output, map[string]string{"ext": ext})
+
+	if tmpl, ok := strings.CutPrefix(flagValue(args, "--progress-template"), "download:"); ok {
+		for i := 1; i <= d.Updates; i++ {
+			done := d.Size * i / d.Updates
+			fmt.Println(expand(tmpl, map[string]string{
+				"progress.downloaded_bytes": strconv.Itoa(done),
+				"progress.total_bytes":      strconv.Itoa(d.Size),
+				"progress.speed":            "1048576.0",
+				"progress.eta":              strconv.Itoa(d.Updates - i),
+			}))
+		}
+	}
+
+	if err := os.WriteFile(path, make([]byte, d.Size), 0644); err != nil {
+		return err
+	}
+
+	if tmpl, ok := strings.CutPrefix(flagValue(args, "--print"), "after_move:"); ok {
+		fields := map[string]string{"filepath": path, "ext": ext}
+		if d.Duration > 0 {
+			fields["duration"] = strconv.FormatFloat(d.Duration, 'f', -1, 64)
+		}
+		fmt.Println(expand(tmpl, fields))
+	}
+	return nil
+}
+
+// flagValue returns the argument following name
+func flagValue(args []string, name string) string {
+	for i, arg := range args[:max(len(args)-1, 0)] {
+		if arg == name {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// expand fills in a yt-dlp output template. Fields missing from fields are
+// NA, as in yt-dlp.
+func expand(tmpl string, fields map[string]string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(tmpl, "%(")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(tmpl[start:], ")s")
+		if end == -1 {
+			break
+		}
+		b.WriteString(tmpl[:start])
+		value, ok := fields[tmpl[start+2:start+end]]
+		if !ok {
+			value = "NA"
+		}
+		b.WriteString(value)
+		tmpl = tmpl[start+end+2:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
@@ -18,7 +18,7 @@ apt-get update
 echo "2. Installing System Dependencies..."
 # mpv: Media player
 # python3: Required for yt-dlp
-# ffmpeg: Required for stream processing
+# ffmpeg: Required for stream processing and loudness analysis
 # ca-certificates: For HTTPS
 # atomicparsley: Optional but good for metadata
 apt-get install -y mpv python3 ffmpeg ca-certificates atomicparsley